@@ -0,0 +1,152 @@
+package dockerdns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	dockerNetworkLabel = "coredns.dockerdns.network"
+	dockerIPv4Label    = "coredns.dockerdns.ipv4"
+	dockerIPv6Label    = "coredns.dockerdns.ipv6"
+
+	addressAuto    = "auto"
+	addressDisable = "disable"
+)
+
+// addressSelection narrows addresses published for one container:
+//
+//	coredns.dockerdns.network=NETWORK[,NETWORK...]  use only addresses of these networks
+//	coredns.dockerdns.ipv4=auto|disable|IP[,IP...]   publish all own, none or these own ipv4 addresses
+//	coredns.dockerdns.ipv6=auto|disable|IP[,IP...]   the same for ipv6
+//
+// Listed addresses must belong to the container, so a label can't take over
+// names and PTR records of another container or host.
+type addressSelection struct {
+	networks []string
+	ipv4     []net.IP
+	ipv6     []net.IP
+	no4, no6 bool
+}
+
+// parseAddressSelection reads address selection labels of container.
+func parseAddressSelection(labels map[string]string) (*addressSelection, error) {
+	s := &addressSelection{}
+	if val, ok := labels[dockerNetworkLabel]; ok {
+		for _, name := range splitLabelList(val) {
+			if !validDockerNetworkName(name) {
+				return nil, fmt.Errorf("label %s: invalid network name: %s", dockerNetworkLabel, name)
+			}
+			s.networks = append(s.networks, name)
+		}
+	}
+	var err error
+	if s.ipv4, s.no4, err = parseAddressLabel(labels, dockerIPv4Label, true); err != nil {
+		return nil, err
+	}
+	if s.ipv6, s.no6, err = parseAddressLabel(labels, dockerIPv6Label, false); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func parseAddressLabel(labels map[string]string, key string, v4 bool) ([]net.IP, bool, error) {
+	val, ok := labels[key]
+	if !ok {
+		return nil, false, nil
+	}
+	switch strings.TrimSpace(val) {
+	case "", addressAuto:
+		return nil, false, nil
+	case addressDisable:
+		return nil, true, nil
+	}
+	ipv4, ipv6, err := splitIPs(splitLabelList(val))
+	if err != nil {
+		return nil, false, fmt.Errorf("label %s: %s", key, err)
+	}
+	if v4 && len(ipv6) != 0 || !v4 && len(ipv4) != 0 {
+		return nil, false, fmt.Errorf("label %s: address of wrong family: %s", key, val)
+	}
+	if v4 {
+		return ipv4, false, nil
+	}
+	return ipv6, false, nil
+}
+
+// splitLabelList splits comma separated label value, empty items are skipped.
+func splitLabelList(val string) []string {
+	res := []string{}
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
+}
+
+// network reports whether addresses of network are selected.
+func (s *addressSelection) network(name string) bool {
+	if len(s.networks) == 0 {
+		return true
+	}
+	for _, n := range s.networks {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// apply narrows or drops addresses found in container networks,
+// notes explain listed addresses the container doesn't have.
+func (s *addressSelection) apply(ipv4, ipv6 []net.IP) ([]net.IP, []net.IP, []string) {
+	var notes []string
+	switch {
+	case s.no4:
+		ipv4 = nil
+	case len(s.ipv4) != 0:
+		ipv4 = pickOwn(ipv4, s.ipv4, dockerIPv4Label, &notes)
+	}
+	switch {
+	case s.no6:
+		ipv6 = nil
+	case len(s.ipv6) != 0:
+		ipv6 = pickOwn(ipv6, s.ipv6, dockerIPv6Label, &notes)
+	}
+	return ipv4, ipv6, notes
+}
+
+// pickOwn returns listed addresses found in own ones.
+func pickOwn(own, listed []net.IP, label string, notes *[]string) []net.IP {
+	res := []net.IP{}
+	for _, ip := range listed {
+		if containsIP(own, ip) {
+			res = append(res, ip)
+			continue
+		}
+		*notes = append(*notes, fmt.Sprintf("label %s: address %s doesn't belong to container networks", label, ip))
+	}
+	return res
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for i := range ips {
+		if ips[i].Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// commonIPs returns addresses of ips present in other.
+func commonIPs(ips, other []net.IP) []net.IP {
+	var res []net.IP
+	for _, ip := range ips {
+		if containsIP(other, ip) {
+			res = append(res, ip)
+		}
+	}
+	return res
+}
@@ -0,0 +1,103 @@
+package dockerdns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	dockerapi "github.com/fsouza/go-dockerclient"
+)
+
+func TestAddressSelection(t *testing.T) {
+	networks := map[string]dockerapi.ContainerNetwork{
+		"frontend": {IPAddress: "172.28.0.2", GlobalIPv6Address: "fd00::2"},
+		"backend":  {IPAddress: "10.0.0.2"},
+	}
+	tests := []struct {
+		name      string
+		labels    map[string]string
+		want4     []net.IP
+		want6     []net.IP
+		wantNotes int
+		wantErr   bool
+	}{
+		{
+			name:   "network",
+			labels: map[string]string{dockerNetworkLabel: "backend"},
+			want4:  []net.IP{parseIP("10.0.0.2")},
+		},
+		{
+			name:   "own ipv4 and disabled ipv6",
+			labels: map[string]string{dockerIPv4Label: "10.0.0.2", dockerIPv6Label: "disable"},
+			want4:  []net.IP{parseIP("10.0.0.2")},
+		},
+		{
+			name:      "address of another container",
+			labels:    map[string]string{dockerIPv4Label: "10.0.0.5,172.28.0.2"},
+			want4:     []net.IP{parseIP("172.28.0.2")},
+			want6:     []net.IP{parseIP("fd00::2")},
+			wantNotes: 1,
+		},
+		{
+			name:      "own address of not selected network",
+			labels:    map[string]string{dockerNetworkLabel: "frontend", dockerIPv4Label: "10.0.0.2"},
+			want4:     []net.IP{},
+			want6:     []net.IP{parseIP("fd00::2")},
+			wantNotes: 1,
+		},
+		{
+			name:   "network and auto addresses",
+			labels: map[string]string{dockerNetworkLabel: "frontend", dockerIPv4Label: "auto"},
+			want4:  []net.IP{parseIP("172.28.0.2")},
+			want6:  []net.IP{parseIP("fd00::2")},
+		},
+		{
+			name:    "unknown network",
+			labels:  map[string]string{dockerNetworkLabel: "other"},
+			wantErr: true,
+		},
+		{
+			name:    "ipv6 address in ipv4 label",
+			labels:  map[string]string{dockerIPv4Label: "fd00::5"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dd := NewDockerDiscovery("")
+			container := &dockerapi.Container{
+				ID:              "0123456789abcdef",
+				Name:            "/web",
+				Config:          &dockerapi.Config{Labels: tt.labels},
+				HostConfig:      &dockerapi.HostConfig{},
+				NetworkSettings: &dockerapi.NetworkSettings{Networks: networks},
+			}
+			var got4, got6 []net.IP
+			var notes []string
+			sel, err := parseAddressSelection(tt.labels)
+			if err == nil {
+				got4, got6, notes, err = dd.getContainerAddresses(container, sel)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getContainerAddresses() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(notes) != tt.wantNotes {
+				t.Errorf("getContainerAddresses() notes = %v, want %d", notes, tt.wantNotes)
+			}
+			if !reflect.DeepEqual(ipStrings(got4), ipStrings(tt.want4)) || !reflect.DeepEqual(ipStrings(got6), ipStrings(tt.want6)) {
+				t.Errorf("getContainerAddresses() = %v, %v, want %v, %v", got4, got6, tt.want4, tt.want6)
+			}
+		})
+	}
+}
+
+func ipStrings(ips []net.IP) []string {
+	res := []string{}
+	for _, ip := range ips {
+		res = append(res, ip.String())
+	}
+	return res
+}
@@ -0,0 +1,76 @@
+package dockerdns
+
+import (
+	"github.com/miekg/dns"
+)
+
+const (
+	anyMinimal = "minimal"
+	anyFull    = "full"
+
+	// anyHINFOTTL is the ttl of RFC 8482 synthesized HINFO record
+	anyHINFOTTL = 8482
+)
+
+// anyRecords answers ANY query for existing name. Minimal mode answers with
+// synthesized HINFO record as RFC 8482 suggests, full mode returns records
+// of every type of the name, the same as queries of these types do.
+func (dd *DockerDiscovery) anyRecords(v *view, qname, zone string, sgn *signer) []dns.RR {
+	if !dd.nameExists(v, qname, zone) {
+		return nil
+	}
+	if dd.opts.anyMode != anyFull {
+		return []dns.RR{&dns.HINFO{
+			Hdr: dns.RR_Header{Name: qname, Rrtype: dns.TypeHINFO, Class: dns.ClassINET, Ttl: anyHINFOTTL},
+			Cpu: "RFC8482",
+		}}
+	}
+	var res []dns.RR
+	for _, t := range dd.nameTypes(v, qname, zone) {
+		// signatures are added on signing, DNSKEY is answered by signer
+		if t == dns.TypeRRSIG || t == dns.TypeDNSKEY {
+			continue
+		}
+		res = append(res, dd.typeRecords(v, qname, zone, t, sgn)...)
+	}
+	return res
+}
+
+// glue returns addresses of targets of MX, SRV, HTTPS and SVCB answers
+// visible in view and missing in answers, so clients don't need extra queries.
+func (dd *DockerDiscovery) glue(v *view, answers []dns.RR) []dns.RR {
+	var res []dns.RR
+	seen := map[string]struct{}{}
+	for _, rr := range answers {
+		if t := rr.Header().Rrtype; t == dns.TypeA || t == dns.TypeAAAA {
+			seen[rr.Header().Name] = struct{}{}
+		}
+	}
+	for _, rr := range answers {
+		var target string
+		switch r := rr.(type) {
+		case *dns.MX:
+			target = r.Mx
+		case *dns.SRV:
+			target = r.Target
+		case *dns.HTTPS:
+			target = r.Target
+		case *dns.SVCB:
+			target = r.Target
+		case *dns.NS:
+			target = r.Ns
+		}
+		if target == "" || target == "." {
+			continue
+		}
+		target = dns.CanonicalName(target)
+		zone := dd.matchOrigin(target)
+		if _, ok := seen[target]; ok || zone == "" {
+			continue
+		}
+		seen[target] = struct{}{}
+		res = append(res, dd.typeRecords(v, target, zone, dns.TypeA, nil)...)
+		res = append(res, dd.typeRecords(v, target, zone, dns.TypeAAAA, nil)...)
+	}
+	return res
+}
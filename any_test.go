@@ -0,0 +1,73 @@
+package dockerdns
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+func TestAnyAndNoData(t *testing.T) {
+	dd := NewDockerDiscovery("")
+	dd.Origins = []string{"loc.", "in-addr.arpa."}
+	dd.connected = 1
+	dd.opts.autoReverse = true
+	dd.opts.hostIPv4 = []net.IP{parseIP("10.0.0.53")}
+	mail := &ContainerData{id: "mail", hosts: []string{"mail.loc."}, ipv4: []net.IP{parseIP("172.28.0.2")},
+		https: &httpsParams{alpn: []string{"h2"}}}
+	dd.parseRecordLabels(map[string]string{dockerRecordLabelPrefix + "mx": "mail.loc. 300 IN MX 10 mail.loc."}, mail)
+	dd.hmap.addContainer(mail)
+
+	tests := []struct {
+		name       string
+		anyMode    string
+		qname      string
+		qtype      uint16
+		wantRcode  int
+		wantAnswer []uint16
+		wantNs     int
+		wantExtra  int
+	}{
+		{name: "minimal any", qname: "mail.loc.", qtype: dns.TypeANY, wantAnswer: []uint16{dns.TypeHINFO}},
+		{name: "full any", anyMode: anyFull, qname: "mail.loc.", qtype: dns.TypeANY,
+			wantAnswer: []uint16{dns.TypeA, dns.TypeMX, dns.TypeSVCB, dns.TypeHTTPS}},
+		{name: "full any of address", anyMode: anyFull, qname: "2.0.28.172.in-addr.arpa.", qtype: dns.TypeANY,
+			wantAnswer: []uint16{dns.TypePTR}},
+		{name: "full any of apex", anyMode: anyFull, qname: "loc.", qtype: dns.TypeANY, wantAnswer: []uint16{dns.TypeNS, dns.TypeSOA},
+			wantExtra: 1},
+		{name: "nodata", qname: "mail.loc.", qtype: dns.TypeTXT, wantNs: 1},
+		{name: "nodata aaaa", qname: "mail.loc.", qtype: dns.TypeAAAA, wantNs: 1},
+		{name: "mx with glue", qname: "mail.loc.", qtype: dns.TypeMX, wantAnswer: []uint16{dns.TypeMX}, wantExtra: 1},
+		{name: "missing name", qname: "none.loc.", qtype: dns.TypeANY, wantRcode: dns.RcodeNameError, wantNs: 1},
+		{name: "missing name a", qname: "none.loc.", qtype: dns.TypeA, wantRcode: dns.RcodeNameError, wantNs: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dd.opts.anyMode = tt.anyMode
+			r := new(dns.Msg)
+			r.SetQuestion(tt.qname, tt.qtype)
+			rec := dnstest.NewRecorder(&test.ResponseWriter{})
+			if _, err := dd.ServeDNS(context.Background(), rec, r); err != nil {
+				t.Fatalf("ServeDNS() error = %v", err)
+			}
+			m := rec.Msg
+			if m == nil || m.Rcode != tt.wantRcode {
+				t.Fatalf("ServeDNS() = %v, want %s", m, dns.RcodeToString[tt.wantRcode])
+			}
+			if tt.wantNs != 0 && m.Ns[0].Header().Rrtype != dns.TypeSOA {
+				t.Errorf("authority = %v, want SOA", m.Ns)
+			}
+			if len(m.Answer) != len(tt.wantAnswer) || len(m.Ns) != tt.wantNs || len(m.Extra) != tt.wantExtra {
+				t.Fatalf("ServeDNS() = %v, want %d answers, %d ns, %d extra", m, len(tt.wantAnswer), tt.wantNs, tt.wantExtra)
+			}
+			for i, rr := range m.Answer {
+				if rr.Header().Rrtype != tt.wantAnswer[i] {
+					t.Errorf("answer %d = %s, want type %s", i, rr, dns.TypeToString[tt.wantAnswer[i]])
+				}
+			}
+		})
+	}
+}
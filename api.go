@@ -0,0 +1,270 @@
+package dockerdns
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/reuseport"
+)
+
+// apiServer serves current state of the plugin as JSON:
+//
+//	/containers      tracked containers
+//	/names           host names with their addresses
+//	/addresses       addresses with their host names
+//	/options         effective options
+//	/events          the latest processed docker events
+//	/explain/NAME    why container NAME (or owner of host NAME) was or wasn't published
+type apiServer struct {
+	addr    string
+	dd      *DockerDiscovery
+	handler http.Handler
+
+	mu  sync.Mutex
+	srv *http.Server // nil unless started
+	ln  net.Listener
+}
+
+type containerView struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Hostname string   `json:"hostname,omitempty"`
+	Project  string   `json:"project,omitempty"`
+	Service  string   `json:"service,omitempty"`
+	Networks []string `json:"networks,omitempty"`
+	IPv4     []net.IP `json:"ipv4,omitempty"`
+	IPv6     []net.IP `json:"ipv6,omitempty"`
+	Hosts    []string `json:"hosts"`
+	TTL      *uint32  `json:"ttl,omitempty"`
+	Records  []string `json:"records,omitempty"`
+	Stale    bool     `json:"stale,omitempty"`
+}
+
+type optionsView struct {
+	Origins          []string `json:"origins"`
+	DockerEndpoint   string   `json:"endpoint"`
+	ByDomain         bool     `json:"by_domain"`
+	ByHostname       bool     `json:"by_hostname"`
+	ByLabel          bool     `json:"by_label"`
+	ByComposeDomain  bool     `json:"by_compose_domain"`
+	ByComposeIndex   bool     `json:"by_compose_index"`
+	ByComposeProject bool     `json:"by_compose_project"`
+	EnabledByDefault bool     `json:"enabled_by_default"`
+	EnabledProjects  []string `json:"enabled_projects,omitempty"`
+	Networks         []string `json:"networks"`
+	TTL              uint32   `json:"ttl"`
+	ReverseTTL       *uint32  `json:"reverse_ttl,omitempty"`
+	NegativeTTL      *uint32  `json:"negative_ttl,omitempty"`
+	AutoReverse      bool     `json:"auto_reverse"`
+	WithdrawPaused   bool     `json:"withdraw_paused"`
+	Include          []string `json:"include,omitempty"`
+	Exclude          []string `json:"exclude,omitempty"`
+	HostNetworkIP    []net.IP `json:"host_network_ip,omitempty"`
+	PublishMode      string   `json:"publish_mode"`
+	PublishIP        []net.IP `json:"publish_ip,omitempty"`
+	Conflict         string   `json:"conflict"`
+	ProjectZones     []string `json:"project_zones,omitempty"`
+	Rewrites         []string `json:"rewrites,omitempty"`
+	Any              string   `json:"any"`
+	Order            string   `json:"order"`
+	Views            []string `json:"views,omitempty"`
+	Signed           []string `json:"signed_zones,omitempty"`
+	Serial           uint32   `json:"serial"`
+	Snapshot         string   `json:"snapshot,omitempty"`
+}
+
+func newAPIServer(addr string, dd *DockerDiscovery) *apiServer {
+	a := &apiServer{addr: addr, dd: dd}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers", a.containers)
+	mux.HandleFunc("/names", a.names)
+	mux.HandleFunc("/addresses", a.addresses)
+	mux.HandleFunc("/options", a.options)
+	mux.HandleFunc("/events", a.events)
+	mux.HandleFunc("/explain/", a.explain)
+	a.handler = mux
+	return a
+}
+
+// start listens on addr with SO_REUSEPORT, so the server of reloaded
+// configuration may bind the address while the old one is stopping.
+func (a *apiServer) start() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.srv != nil {
+		return nil
+	}
+	ln, err := reuseport.Listen("tcp", a.addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: a.handler, ReadHeaderTimeout: 5 * time.Second}
+	a.srv, a.ln = srv, ln
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Errorf("[docker] api server %s: %s", a.addr, err)
+		}
+	}()
+	log.Infof("[docker] api server listens on %s", ln.Addr())
+	return nil
+}
+
+// stop closes the listener, it is called on reload and final shutdown.
+func (a *apiServer) stop() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.srv == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// Serve may not track the listener yet, so it is closed here as well
+	a.ln.Close()
+	err := a.srv.Shutdown(ctx)
+	a.srv, a.ln = nil, nil
+	return err
+}
+
+func (c *ContainerData) view() containerView {
+	records := make([]string, 0, len(c.records))
+	for _, rr := range c.records {
+		records = append(records, rr.String())
+	}
+	return containerView{
+		ID:       c.id,
+		Name:     c.name,
+		Hostname: c.hostname,
+		Project:  c.project,
+		Service:  c.service,
+		Networks: c.networks,
+		IPv4:     c.ipv4,
+		IPv6:     c.ipv6,
+		Hosts:    c.hosts,
+		TTL:      c.ttl,
+		Records:  records,
+		Stale:    c.stale,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Errorf("[docker] api write response: %s", err)
+	}
+}
+
+func (a *apiServer) containers(w http.ResponseWriter, _ *http.Request) {
+	res := []containerView{}
+	a.dd.hmap.ids.Range(func(_ string, c *ContainerData) bool {
+		res = append(res, c.view())
+		return false
+	})
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	writeJSON(w, res)
+}
+
+func (a *apiServer) names(w http.ResponseWriter, _ *http.Request) {
+	res := map[string][]net.IP{}
+	add := func(host string, ips []net.IP) bool {
+		res[host] = append(res[host], ips...)
+		return false
+	}
+	a.dd.hmap.name4.Range(add)
+	a.dd.hmap.name6.Range(add)
+	writeJSON(w, res)
+}
+
+func (a *apiServer) addresses(w http.ResponseWriter, _ *http.Request) {
+	res := map[string][]string{}
+	a.dd.hmap.addr.Range(func(ip string, names []string) bool {
+		res[ip] = names
+		return false
+	})
+	writeJSON(w, res)
+}
+
+func (a *apiServer) options(w http.ResponseWriter, _ *http.Request) {
+	opts := &a.dd.opts
+	v := optionsView{
+		Origins:          a.dd.Origins,
+		DockerEndpoint:   opts.dockerEndpoint,
+		ByDomain:         opts.byDomain,
+		ByHostname:       opts.byHostname,
+		ByLabel:          opts.byLabel,
+		ByComposeDomain:  opts.byComposeDomain,
+		ByComposeIndex:   opts.byComposeIndex,
+		ByComposeProject: opts.byComposeProject,
+		EnabledByDefault: opts.enabledByDefault,
+		EnabledProjects:  opts.enabledProjects,
+		Networks:         a.dd.networks(),
+		TTL:              opts.ttl,
+		ReverseTTL:       opts.reverseTTL,
+		NegativeTTL:      opts.negativeTTL,
+		AutoReverse:      opts.autoReverse,
+		WithdrawPaused:   opts.withdrawPaused,
+		HostNetworkIP:    append(append([]net.IP{}, opts.hostIPv4...), opts.hostIPv6...),
+		PublishMode:      opts.publishMode,
+		PublishIP:        append(append([]net.IP{}, opts.publishIPv4...), opts.publishIPv6...),
+		Conflict:         a.dd.hmap.policy(),
+		Serial:           a.dd.hmap.currentSerial(),
+	}
+	if a.dd.snapshot != nil {
+		v.Snapshot = a.dd.snapshot.path
+	}
+	if v.Any = opts.anyMode; v.Any == "" {
+		v.Any = anyMinimal
+	}
+	for _, view := range opts.views {
+		v.Views = append(v.Views, view.String())
+	}
+	v.Order = orderStatic
+	if opts.order != nil {
+		v.Order = opts.order.String()
+	}
+	if v.PublishMode == "" {
+		v.PublishMode = publishModeContainer
+	}
+	for _, f := range opts.include {
+		v.Include = append(v.Include, f.String())
+	}
+	for _, f := range opts.exclude {
+		v.Exclude = append(v.Exclude, f.String())
+	}
+	for _, p := range opts.projectZones {
+		v.ProjectZones = append(v.ProjectZones, p.String())
+	}
+	for _, r := range opts.rewrites {
+		v.Rewrites = append(v.Rewrites, r.String())
+	}
+	for zone := range a.dd.signers {
+		v.Signed = append(v.Signed, zone)
+	}
+	sort.Strings(v.Signed)
+	writeJSON(w, v)
+}
+
+func (a *apiServer) events(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, a.dd.events.list())
+}
+
+func (a *apiServer) explain(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/explain/")
+	if name == "" {
+		http.Error(w, "container or host name is required", http.StatusBadRequest)
+		return
+	}
+	res := a.dd.explain(name)
+	if len(res) == 0 {
+		http.Error(w, "no container found for "+name, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, res)
+}
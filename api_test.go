@@ -0,0 +1,99 @@
+package dockerdns
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIServer(t *testing.T) {
+	dd := NewDockerDiscovery("")
+	dd.Origins = []string{"loc."}
+	c := &ContainerData{id: "0123456789abcdef", name: "web", hosts: []string{"web.loc."}, ipv4: []net.IP{parseIP("172.28.0.2")}}
+	dd.hmap.addContainer(c)
+	dd.decide(c, true, reason{reasonEnabledByDefault, "enabled by default"})
+	dd.decide(&ContainerData{id: "fedcba9876543210", name: "db"}, false, reason{reasonNotEnabled, "not enabled"})
+	a := newAPIServer("127.0.0.1:0", dd)
+
+	tests := []struct {
+		path   string
+		status int
+		want   interface{}
+		check  func(t *testing.T, v interface{})
+	}{
+		{path: "/containers", status: http.StatusOK, want: &[]containerView{}, check: func(t *testing.T, v interface{}) {
+			if got := *v.(*[]containerView); len(got) != 1 || got[0].Name != "web" {
+				t.Errorf("containers = %+v", got)
+			}
+		}},
+		{path: "/names", status: http.StatusOK, want: &map[string][]string{}, check: func(t *testing.T, v interface{}) {
+			if got := *v.(*map[string][]string); len(got["web.loc."]) != 1 || got["web.loc."][0] != "172.28.0.2" {
+				t.Errorf("names = %+v", got)
+			}
+		}},
+		{path: "/explain/web.loc", status: http.StatusOK, want: &[]decision{}, check: func(t *testing.T, v interface{}) {
+			if got := *v.(*[]decision); len(got) != 1 || !got[0].Published {
+				t.Errorf("explain = %+v", got)
+			}
+		}},
+		{path: "/explain/db", status: http.StatusOK, want: &[]decision{}, check: func(t *testing.T, v interface{}) {
+			if got := *v.(*[]decision); len(got) != 1 || got[0].Published || got[0].Reason.Code != reasonNotEnabled {
+				t.Errorf("explain = %+v", got)
+			}
+		}},
+		{path: "/explain/cache", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			a.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.want == nil {
+				return
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), tt.want); err != nil {
+				t.Fatalf("unmarshal %s: %v", rec.Body.String(), err)
+			}
+			tt.check(t, tt.want)
+		})
+	}
+}
+
+func TestAPIServerReload(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	old := newAPIServer(addr, NewDockerDiscovery(""))
+	if err := old.start(); err != nil {
+		t.Fatalf("start() error = %v", err)
+	}
+	// reload: new instance starts while the old one still listens
+	reloaded := newAPIServer(addr, NewDockerDiscovery(""))
+	if err := reloaded.start(); err != nil {
+		t.Fatalf("start() of reloaded instance error = %v", err)
+	}
+	if err := old.stop(); err != nil {
+		t.Fatalf("stop() error = %v", err)
+	}
+	resp, err := http.Get("http://" + addr + "/options")
+	if err != nil {
+		t.Fatalf("reloaded api is not served: %v", err)
+	}
+	resp.Body.Close()
+	if err := reloaded.stop(); err != nil {
+		t.Fatalf("stop() error = %v", err)
+	}
+	// failed reload restores the old listener
+	if err := old.start(); err != nil {
+		t.Fatalf("restart of stopped instance error = %v", err)
+	}
+	old.stop()
+}
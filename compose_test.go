@@ -0,0 +1,49 @@
+package dockerdns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestComposeIndex(t *testing.T) {
+	dd := NewDockerDiscovery("")
+	dd.Origins = []string{"loc."}
+	dd.addRZones()
+	dd.opts.byComposeDomain = true
+	dd.opts.byComposeIndex = true
+
+	replicas := []*ContainerData{
+		{id: "one", project: "proj", service: "db", number: "1", ipv4: []net.IP{parseIP("172.28.0.2")}},
+		{id: "two", project: "proj", service: "db", number: "2", ipv4: []net.IP{parseIP("172.28.0.3")}},
+	}
+	for _, c := range replicas {
+		dd.resolveHosts(c)
+		dd.hmap.addContainer(c)
+	}
+	if want := []string{"db.proj.loc.", "1.db.proj.loc."}; !reflect.DeepEqual(replicas[0].hosts, want) {
+		t.Errorf("hosts = %v, want %v", replicas[0].hosts, want)
+	}
+	if ips, _ := dd.hmap.name4.Load("db.proj.loc."); len(ips) != 2 {
+		t.Errorf("shared name addresses = %v, want both replicas", ips)
+	}
+	if ips, _ := dd.hmap.name4.Load("2.db.proj.loc."); len(ips) != 1 || !ips[0].Equal(parseIP("172.28.0.3")) {
+		t.Errorf("indexed name addresses = %v, want 172.28.0.3", ips)
+	}
+}
+
+func TestComposeProject(t *testing.T) {
+	dd := NewDockerDiscovery("")
+	dd.Origins = []string{"loc."}
+	dd.addRZones()
+	dd.opts.byComposeProject = true
+
+	for i, service := range []string{"web", "db"} {
+		c := &ContainerData{id: service, project: "proj", service: service, ipv4: []net.IP{net.IPv4(172, 28, 0, byte(i+2))}}
+		dd.resolveHosts(c)
+		dd.hmap.addContainer(c)
+	}
+	if ips, _ := dd.hmap.name4.Load("proj.loc."); len(ips) != 2 {
+		t.Errorf("project name addresses = %v, want all containers of project", ips)
+	}
+}
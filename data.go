@@ -6,6 +6,7 @@ import (
 	"strconv"
 
 	dockerapi "github.com/fsouza/go-dockerclient"
+	"github.com/miekg/dns"
 )
 
 // type ContainerData struct {
@@ -21,6 +22,17 @@ var labels = []string{
 	dockerEnableLabel,
 	dockerProjectLabel,
 	dockerServiceLabel,
+	dockerNumberLabel,
+	dockerTTLLabel,
+	dockerNetworkLabel,
+	dockerIPv4Label,
+	dockerIPv6Label,
+	dockerZonesLabel,
+	dockerHTTPSAlpnLabel,
+	dockerHTTPSPortLabel,
+	dockerHTTPSECHLabel,
+	dockerWeightLabel,
+	dockerPriorityLabel,
 }
 
 type ContainerData struct {
@@ -34,9 +46,22 @@ type ContainerData struct {
 	forceDisabled bool
 	project       string
 	service       string
+	number        string // compose replica index
 	ipv4          []net.IP
 	ipv6          []net.IP
 	hosts         []string
+	shared        []string // hosts shared with other containers on purpose, i.e. compose service names
+	ttl           *uint32  // ttl label, default ttl is used when nil
+	zones         []string // zones label, nil when label is not set
+	https         *httpsParams
+	records       []dns.RR // records of record labels
+	weight        *uint32  // weight label, nil when label is not set
+	priority      uint32
+	image         string
+	labels        map[string]string
+	bindings      []string // host ip addresses of published ports
+	notes         []string // remarks for decision explainer
+	stale         bool     // loaded from snapshot and not confirmed by scan yet
 }
 
 func newContainerConfig(container *dockerapi.Container) *ContainerData {
@@ -51,20 +76,69 @@ func newContainerConfig(container *dockerapi.Container) *ContainerData {
 		}
 	}
 
-	return &ContainerData{
+	c := &ContainerData{
 		labeledHost:   container.Config.Labels[dockerHostLabel],
 		enabled:       enabled,
 		forceDisabled: disabled,
 		project:       container.Config.Labels[dockerProjectLabel],
 		service:       container.Config.Labels[dockerServiceLabel],
+		number:        container.Config.Labels[dockerNumberLabel],
+		image:         container.Config.Image,
+		labels:        container.Config.Labels,
 	}
+	if val, ok := container.Config.Labels[dockerZonesLabel]; ok {
+		c.zones = parseZonesLabel(val)
+	}
+	parseWeightLabels(container.Config.Labels, c)
+	if https, err := parseHTTPSLabels(container.Config.Labels); err != nil {
+		c.notes = append(c.notes, err.Error())
+	} else {
+		c.https = https
+	}
+	if val, ok := container.Config.Labels[dockerTTLLabel]; ok {
+		ttl, err := parseTTL(val)
+		if err != nil {
+			c.notes = append(c.notes, fmt.Sprintf("label %s: %s", dockerTTLLabel, err))
+		} else {
+			c.ttl = &ttl
+		}
+	}
+	return c
 }
 
+// parseTTL parses ttl in seconds in range [0, maxTTL].
+func parseTTL(s string) (uint32, error) {
+	t, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || t > maxTTL {
+		return 0, fmt.Errorf("ttl must be in range [0, %d]: %s", maxTTL, s)
+	}
+	return uint32(t), nil
+}
+
+// labelError is an invalid label value which leaves container unpublished.
+type labelError struct {
+	err error
+}
+
+func (e *labelError) Error() string { return e.err.Error() }
+
+func (e *labelError) Unwrap() error { return e.err }
+
 func (dd *DockerDiscovery) parseContainer(container *dockerapi.Container) (*ContainerData, error) {
 	c := newContainerConfig(container)
+	dd.parseRecordLabels(container.Config.Labels, c)
+	sel, selErr := parseAddressSelection(container.Config.Labels)
+	if selErr != nil {
+		sel = &addressSelection{}
+	}
 	networks := []string{}
 	for name := range container.NetworkSettings.Networks {
 		if !dd.permittedNetwork(name) {
+			c.notes = append(c.notes, fmt.Sprintf("network %s is not permitted", name))
+			continue
+		}
+		if !sel.network(name) {
+			c.notes = append(c.notes, fmt.Sprintf("network %s is not selected by label %s", name, dockerNetworkLabel))
 			continue
 		}
 		networks = append(networks, name)
@@ -73,7 +147,19 @@ func (dd *DockerDiscovery) parseContainer(container *dockerapi.Container) (*Cont
 	c.name = normalizeContainerName(container)
 	c.id = container.ID
 	c.hostname = container.Config.Hostname
-	ipv4, ipv6, err := dd.getContainerAddresses(container)
+	c.bindings = portBindings(container)
+	if dd.opts.publishMode == publishModeHost && wildcardBinding(c.bindings) {
+		if ext4, ext6 := dd.externalAddresses(); len(ext4) == 0 && len(ext6) == 0 {
+			note := "ports are bound to all host addresses, but neither publish_mode addresses nor host_network_ip are set"
+			log.Warningf("[docker] container %s (%s): %s", c.name, c.id[:12], note)
+			c.notes = append(c.notes, note)
+		}
+	}
+	if selErr != nil {
+		return c, &labelError{selErr}
+	}
+	ipv4, ipv6, notes, err := dd.getContainerAddresses(container, sel)
+	c.notes = append(c.notes, notes...)
 	if err != nil {
 		return c, err
 	}
@@ -94,18 +180,49 @@ func (dd *DockerDiscovery) resolveHosts(c *ContainerData) {
 	if dd.opts.byHostname && c.hostname != "" {
 		domains = append(domains, c.hostname)
 	}
+	// service and project names are published by every replica and service
+	shared := map[string]bool{}
 	if dd.opts.byComposeDomain && c.service != "" && c.project != "" {
 		domains = append(domains, c.service+"."+c.project)
+		shared[c.service+"."+c.project] = true
+	}
+	if dd.opts.byComposeProject && c.project != "" {
+		domains = append(domains, c.project)
+		shared[c.project] = true
+	}
+	if dd.opts.byComposeIndex && c.number != "" && c.service != "" && c.project != "" {
+		domains = append(domains, c.number+"."+c.service+"."+c.project)
+	}
+	names := make([]string, 0, len(domains))
+	var sharedNames []string
+	for _, d := range domains {
+		name := dd.rewriteName(d)
+		if name == "" {
+			c.notes = append(c.notes, fmt.Sprintf("name %s is empty after rewrite", d))
+			continue
+		}
+		names = append(names, name)
+		if shared[d] {
+			sharedNames = append(sharedNames, name)
+		}
 	}
-	if len(domains) != 0 {
-		hosts := dd.makeFQDNs(domains)
-		c.hosts = hosts
+	if len(names) != 0 {
+		zones := dd.containerZones(c)
+		c.hosts = dd.makeFQDNs(names, zones)
+		c.shared = dd.makeFQDNs(sharedNames, zones)
 	}
 	if c.labeledHost != "" {
-		dd.addFQDN(c.labeledHost, c)
+		if err := dd.addFQDN(c.labeledHost, c); err != nil {
+			c.notes = append(c.notes, fmt.Sprintf("label %s: %s", dockerHostLabel, err))
+		}
 	}
 }
 
+// sharedHost reports whether container publishes host as a name shared on purpose.
+func (c *ContainerData) sharedHost(host string) bool {
+	return indexOf(c.shared, host) >= 0
+}
+
 func (dd *DockerDiscovery) addFQDN(name string, c *ContainerData) error {
 	if name == "" {
 		return fmt.Errorf("passed empty name")
@@ -126,11 +243,25 @@ func (dd *DockerDiscovery) addFQDN(name string, c *ContainerData) error {
 	return nil
 }
 
+// networks returns networks containers are published from, all when empty.
+func (dd *DockerDiscovery) networks() []string {
+	dd.networksMu.RLock()
+	defer dd.networksMu.RUnlock()
+	return dd.opts.fromNetworks
+}
+
+func (dd *DockerDiscovery) setNetworks(networks []string) {
+	dd.networksMu.Lock()
+	defer dd.networksMu.Unlock()
+	dd.opts.fromNetworks = networks
+}
+
 func (dd *DockerDiscovery) permittedNetwork(network string) bool {
-	if len(dd.opts.fromNetworks) == 0 {
+	networks := dd.networks()
+	if len(networks) == 0 {
 		return true
 	}
-	for _, n := range dd.opts.fromNetworks {
+	for _, n := range networks {
 		if n == network {
 			return true
 		}
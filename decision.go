@@ -0,0 +1,189 @@
+package dockerdns
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	dockerapi "github.com/fsouza/go-dockerclient"
+	"github.com/miekg/dns"
+)
+
+const (
+	// eventLogSize is the number of processed docker events kept for api.
+	eventLogSize = 100
+
+	// explainPrefix is the label of TXT queries explaining decisions: _why.NAME.ZONE
+	explainPrefix = "_why."
+)
+
+// codes of decision reasons
+const (
+	reasonEnabledByLabel   = "enabled_by_label"
+	reasonIncluded         = "included_by_filter"
+	reasonEnabledByProject = "enabled_by_project"
+	reasonEnabledByDefault = "enabled_by_default"
+	reasonDisabledByLabel  = "disabled_by_label"
+	reasonExcluded         = "excluded_by_filter"
+	reasonNotEnabled       = "not_enabled"
+	reasonInvalidLabel     = "invalid_label"
+	reasonNoAddress        = "no_address"
+	reasonNoHosts          = "no_hosts"
+	reasonNotRunning       = "not_running"
+	reasonPaused           = "paused"
+	reasonRemoved          = "removed"
+)
+
+// reason explains decision with a stable code and a human readable message.
+type reason struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (r reason) String() string {
+	return r.Message
+}
+
+// decision is the latest result of container processing.
+type decision struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Published bool      `json:"published"`
+	Reason    reason    `json:"reason"`
+	Notes     []string  `json:"notes,omitempty"`
+	Hosts     []string  `json:"hosts,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// eventRecord is a processed docker event.
+type eventRecord struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Action string    `json:"action"`
+	ID     string    `json:"id"`
+	Name   string    `json:"name,omitempty"`
+}
+
+// eventLog is a ring of the latest processed events.
+type eventLog struct {
+	mu      sync.Mutex
+	size    int
+	next    int
+	entries []eventRecord
+}
+
+func newEventLog(size int) *eventLog {
+	return &eventLog{size: size, entries: make([]eventRecord, 0, size)}
+}
+
+func (l *eventLog) add(msg *dockerapi.APIEvents) {
+	id := msg.Actor.ID
+	if msg.Type == "network" {
+		id = msg.Actor.Attributes["container"]
+	}
+	r := eventRecord{
+		Time:   time.Unix(0, msg.TimeNano),
+		Type:   msg.Type,
+		Action: msg.Action,
+		ID:     id,
+		Name:   msg.Actor.Attributes["name"],
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) < l.size {
+		l.entries = append(l.entries, r)
+		return
+	}
+	l.entries[l.next] = r
+	l.next = (l.next + 1) % l.size
+}
+
+// list returns events from the oldest to the latest.
+func (l *eventLog) list() []eventRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	res := make([]eventRecord, 0, len(l.entries))
+	res = append(res, l.entries[l.next:]...)
+	return append(res, l.entries[:l.next]...)
+}
+
+// decide stores decision about container.
+func (dd *DockerDiscovery) decide(c *ContainerData, published bool, r reason) {
+	if c == nil || c.id == "" {
+		return
+	}
+	d := &decision{
+		ID:        c.id,
+		Name:      c.name,
+		Published: published,
+		Reason:    r,
+		Notes:     c.notes,
+		Hosts:     c.hosts,
+		Time:      time.Now(),
+	}
+	dd.decisions.Store(c.id, d)
+}
+
+// withdraw marks decision about removed container.
+func (dd *DockerDiscovery) withdraw(id string, r reason) {
+	d, ok := dd.decisions.Load(id)
+	if !ok {
+		return
+	}
+	nd := *d
+	nd.Published = false
+	nd.Reason = r
+	nd.Time = time.Now()
+	dd.decisions.Store(id, &nd)
+}
+
+// explain returns decisions about containers having name
+// as container name or as one of published hosts.
+func (dd *DockerDiscovery) explain(name string) []*decision {
+	res := []*decision{}
+	host := plugin.Name(name).Normalize()
+	dd.decisions.Range(func(_ string, d *decision) bool {
+		if d.Name == name || d.ID == name || (len(name) >= 12 && shortID(d.ID) == name) {
+			res = append(res, d)
+			return false
+		}
+		for _, h := range d.Hosts {
+			if h == host {
+				res = append(res, d)
+				break
+			}
+		}
+		return false
+	})
+	return res
+}
+
+// explainTXT answers TXT query _why.NAME.ZONE with one record per container
+// found by host NAME.ZONE or by container name NAME.
+func (dd *DockerDiscovery) explainTXT(qname, zone string) []dns.RR {
+	name := strings.TrimPrefix(qname, explainPrefix)
+	decisions := dd.explain(name)
+	if len(decisions) == 0 && zone != "." {
+		decisions = dd.explain(strings.TrimSuffix(name, "."+zone))
+	}
+	answers := make([]dns.RR, 0, len(decisions))
+	for _, d := range decisions {
+		txt := []string{
+			"container=" + d.Name,
+			"id=" + shortID(d.ID),
+			fmt.Sprintf("published=%t", d.Published),
+			"code=" + d.Reason.Code,
+			"reason=" + d.Reason.Message,
+		}
+		for _, n := range d.Notes {
+			txt = append(txt, "note="+n)
+		}
+		answers = append(answers, &dns.TXT{
+			Hdr: dns.RR_Header{Name: qname, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0},
+			Txt: txt,
+		})
+	}
+	return answers
+}
@@ -0,0 +1,75 @@
+package dockerdns
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	dockerapi "github.com/fsouza/go-dockerclient"
+	"github.com/miekg/dns"
+)
+
+func TestExplainTXT(t *testing.T) {
+	dd := NewDockerDiscovery("")
+	dd.Origins = []string{"loc."}
+	dd.opts.explain = true
+	dd.decide(&ContainerData{
+		id:    "fedcba9876543210",
+		name:  "db",
+		hosts: []string{"db.loc."},
+		notes: []string{"network backend is not permitted"},
+	}, false, reason{reasonNoAddress, "no permitted networks"})
+
+	for _, qname := range []string{"_why.db.loc.", "_why.DB.loc."} {
+		r := new(dns.Msg)
+		r.SetQuestion(qname, dns.TypeTXT)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := dd.ServeDNS(context.Background(), rec, r); err != nil {
+			t.Fatalf("ServeDNS() error = %v", err)
+		}
+		if rec.Msg == nil || len(rec.Msg.Answer) != 1 {
+			t.Fatalf("ServeDNS(%s) = %v, want one TXT record", qname, rec.Msg)
+		}
+		txt := strings.Join(rec.Msg.Answer[0].(*dns.TXT).Txt, " ")
+		for _, want := range []string{"published=false", "code=no_address", "note=network backend is not permitted"} {
+			if !strings.Contains(txt, want) {
+				t.Errorf("TXT = %q, want %q", txt, want)
+			}
+		}
+	}
+}
+
+func TestDecisionReasons(t *testing.T) {
+	invalid := testContainer("0123456789abcdef", "web", "172.17.0.2")
+	invalid.Config.Labels[dockerIPv4Label] = "fd00::5"
+	foreign := testContainer("0123456789abcdee", "api", "172.17.0.3")
+	// address of another container
+	foreign.Config.Labels[dockerIPv4Label] = "172.17.0.2"
+	hostNetwork := testContainer("fedcba9876543210", "db", "")
+	hostNetwork.HostConfig.NetworkMode = hostNetworkMode
+
+	tests := []struct {
+		name      string
+		container *dockerapi.Container
+		want      string
+	}{
+		{name: "invalid address label", container: invalid, want: reasonInvalidLabel},
+		{name: "host network without host_network_ip", container: hostNetwork, want: reasonNoAddress},
+		{name: "address of another container", container: foreign, want: reasonNoAddress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dd := NewDockerDiscovery("")
+			dd.Origins = []string{"loc."}
+			dd.opts.byDomain = true
+			dd.opts.enabledByDefault = true
+			dd.updateContainer(tt.container)
+			d, ok := dd.decisions.Load(tt.container.ID)
+			if !ok || d.Published || d.Reason.Code != tt.want {
+				t.Errorf("decision = %+v, want unpublished with code %s", d, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package dockerdns
 import (
 	"net"
 
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
 	"github.com/miekg/dns"
 )
 
@@ -41,3 +42,30 @@ func ptr(zone string, ttl uint32, names []string) []dns.RR {
 	}
 	return answers
 }
+
+// soa returns SOA record for zone, minttl is the negative caching ttl.
+func soa(zone string, ttl, minttl, serial uint32) dns.RR {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: ttl},
+		Ns:      nsName(zone),
+		Mbox:    dnsutil.Join("hostmaster.dns", zone),
+		Serial:  serial,
+		Refresh: 7200,
+		Retry:   1800,
+		Expire:  86400,
+		Minttl:  minttl,
+	}
+}
+
+// nsName returns name of the plugin nameserver of zone, the MNAME of its SOA.
+func nsName(zone string) string {
+	return dnsutil.Join("ns.dns", zone)
+}
+
+// nsRecord returns NS record of zone pointing to the plugin nameserver.
+func nsRecord(zone string, ttl uint32) dns.RR {
+	return &dns.NS{
+		Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: ttl},
+		Ns:  nsName(zone),
+	}
+}
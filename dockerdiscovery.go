@@ -2,12 +2,17 @@ package dockerdns
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/rand"
 
 	"net"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
 	"github.com/coredns/coredns/plugin/pkg/dnsutil"
 	"github.com/coredns/coredns/plugin/pkg/fall"
 	"github.com/coredns/coredns/request"
@@ -16,18 +21,47 @@ import (
 	"github.com/miekg/dns"
 )
 
+// dockerAPI is the part of docker client used by the plugin, replaced in tests.
+type dockerAPI interface {
+	ListContainers(opts dockerapi.ListContainersOptions) ([]dockerapi.APIContainers, error)
+	InspectContainerWithOptions(opts dockerapi.InspectContainerOptions) (*dockerapi.Container, error)
+	AddEventListener(listener chan<- *dockerapi.APIEvents) error
+	RemoveEventListener(listener chan *dockerapi.APIEvents) error
+}
+
 // DockerDiscovery is a plugin that conforms to the coredns plugin interface
 type DockerDiscovery struct {
 	Next         plugin.Handler
 	Origins      []string
-	dockerClient *dockerapi.Client
+	dockerClient dockerAPI
 	Fall         fall.F
 	opts         dnsControlOpts
 
 	// mutex            sync.RWMutex
 	// containerInfoMap ContainerInfoMap
-	hmap   *Map
-	rzones []string
+	hmap      *Map
+	rzones    []string
+	signers   map[string]*signer // [origin, signer]
+	update    *updateSink
+	exporters []*fileExporter
+	api       *apiServer
+	snapshot  *snapshotStore
+
+	decisions *csm.CsMap[string, *decision] // [container_id, decision]
+	events    *eventLog
+	connected int32 // set when containers are scanned and events are listened
+
+	// connMu serializes connect with shutdown, so event listener
+	// isn't added after the plugin is stopped
+	connMu  sync.Mutex
+	stopped bool
+
+	// networksMu guards opts.fromNetworks, networks of own containers
+	// are found again on every connect
+	networksMu sync.RWMutex
+
+	// intn is rand.Intn, used to choose weighted containers
+	intn func(n int) int
 }
 
 type dnsControlOpts struct {
@@ -36,10 +70,35 @@ type dnsControlOpts struct {
 	byHostname       bool
 	byLabel          bool
 	byComposeDomain  bool
+	byComposeIndex   bool
+	byComposeProject bool
 	enabledByDefault bool
+	enabledProjects  []string
 	fromNetworks     []string
+	ownNetworks      bool // networks are not configured, use networks of own containers found on connect
 	ttl              uint32
+	reverseTTL       *uint32 // ttl of PTR records, ttl is used when nil
+	negativeTTL      *uint32 // SOA minimum ttl, ttl is used when nil
 	autoReverse      bool
+	withdrawPaused   bool
+	include          []*containerFilter
+	exclude          []*containerFilter
+	hostIPv4         []net.IP
+	hostIPv6         []net.IP
+	publishMode      string
+	publishIPv4      []net.IP
+	publishIPv6      []net.IP
+	conflict         string
+	dnssecKeys       []string
+	dnssecDenial     string // nsec or nsec3, nsec when empty
+	explain          bool
+	projectZones     []*projectZones
+	rewrites         []*nameRewrite
+	anyMode          string
+	order            *answerOrder
+	views            []*view
+	// retry connection to docker daemon with this interval instead of failing at start
+	reconnectInterval time.Duration
 }
 
 // NewDockerDiscovery constructs a new DockerDiscovery object
@@ -50,25 +109,19 @@ func NewDockerDiscovery(dockerEndpoint string) *DockerDiscovery {
 	dd := &DockerDiscovery{
 		Origins: make([]string, 0, 10),
 		rzones:  make([]string, 0, 10),
-		hmap: &Map{
-			name4: newCSMap(),
-			name6: newCSMap(),
-			ids: csm.Create[string, *ContainerData](
-				csm.WithShardCount[string, *ContainerData](32),
-				csm.WithSize[string, *ContainerData](100),
-			),
-			addr: csm.Create[string, []string](
-				csm.WithShardCount[string, []string](32),
-				csm.WithSize[string, []string](100),
-			),
-		},
+		decisions: csm.Create[string, *decision](
+			csm.WithShardCount[string, *decision](32),
+			csm.WithSize[string, *decision](100),
+		),
+		events: newEventLog(eventLogSize),
+		intn:   rand.Intn,
 		opts: dnsControlOpts{
 			dockerEndpoint: dockerEndpoint,
 			byLabel:        true,
 			ttl:            defaultTTL,
 		},
 	}
-	dd.hmap.autoReverse = &dd.opts.autoReverse
+	dd.hmap = newMap(&dd.opts.autoReverse, &dd.opts.conflict, &dd.opts.ttl)
 	return dd
 }
 
@@ -85,148 +138,369 @@ func (dd *DockerDiscovery) ServeDNS(ctx context.Context, w dns.ResponseWriter, r
 		}
 	}
 
+	v := dd.findView(state.IP()).forRequest()
+	if zone != "" && !v.hasZone(zone) {
+		return plugin.NextOrFailure(dd.Name(), dd.Next, ctx, w, r)
+	}
+
+	sgn := dd.signers[zone]
+	if state.QType() == dns.TypeDNSKEY && sgn != nil && qname == zone {
+		return dd.writeMsg(ctx, state, sgn, sgn.dnskey(state, dd.opts.ttl))
+	}
+
 	var answers []dns.RR
 	switch state.QType() {
 	case dns.TypePTR:
-		addr := dnsutil.ExtractAddressFromReverse(qname)
-		names, ok := dd.hmap.addr.Load(addr)
-		if !ok {
+		if len(dd.ptrNames(v, dnsutil.ExtractAddressFromReverse(qname))) == 0 {
 			return plugin.NextOrFailure(dd.Name(), dd.Next, ctx, w, r)
 		}
-		answers = ptr(qname, dd.opts.ttl, names)
-	case dns.TypeA:
-		ips, ok := dd.hmap.name4.Load(state.QName())
-		if ok {
-			answers = a(qname, dd.opts.ttl, ips)
-		}
-	case dns.TypeAAAA:
-		ips, ok := dd.hmap.name6.Load(state.QName())
-		if ok {
-			answers = aaaa(qname, dd.opts.ttl, ips)
-		}
+		answers = dd.typeRecords(v, qname, zone, dns.TypePTR, sgn)
+	case dns.TypeANY:
+		answers = dd.anyRecords(v, qname, zone, sgn)
+	default:
+		answers = dd.typeRecords(v, qname, zone, state.QType(), sgn)
 	}
 
+	m := new(dns.Msg)
+	m.SetReply(r)
+
 	// Only on NXDOMAIN we will fallthrough.
 	if len(answers) == 0 {
-		if dd.Fall.Through(qname) {
+		exists := dd.nameExists(v, qname, zone)
+		// records are incomplete until docker daemon is connected
+		if (!exists && dd.Fall.Through(qname)) || !dd.isConnected() {
 			return plugin.NextOrFailure(dd.Name(), dd.Next, ctx, w, r)
 		}
 
-		// We want to send an NXDOMAIN, but because of /etc/hosts' setup we don't have a SOA, so we make it SERVFAIL
-		// to at least give an answer back to signals we're having problems resolving this.
-		return dns.RcodeServerFailure, nil
+		// existing names answer NODATA, missing names NXDOMAIN,
+		// both with SOA of the zone for negative caching
+		if !exists {
+			m.Rcode = dns.RcodeNameError
+		}
+		// RFC 2308: negative answers are cached for the lower of SOA ttl and minimum
+		apex := dd.soa(zone, dd.hmap.currentSerial())
+		if min := apex.(*dns.SOA).Minttl; min < apex.Header().Ttl {
+			apex.Header().Ttl = min
+		}
+		m.Ns = []dns.RR{apex}
+		if sgn != nil && sgn.nsec3 && state.Do() {
+			m.Ns = append(m.Ns, dd.nsec3Denial(v, qname, zone, exists, apex.Header().Ttl)...)
+		}
 	}
 
-	m := new(dns.Msg)
-	m.SetReply(r)
-	m.Authoritative, m.RecursionAvailable, m.Compress = true, false, true
+	if dd.opts.order != nil {
+		dd.opts.order.apply(answers)
+	}
 	m.Answer = answers
+	m.Extra = dd.glue(v, answers)
+	return dd.writeMsg(ctx, state, sgn, m)
+}
+
+// typeRecords returns records of qname of type qtype answered in view v.
+// Every answer, including ones of ANY query, is built here.
+func (dd *DockerDiscovery) typeRecords(v *view, qname, zone string, qtype uint16, sgn *signer) []dns.RR {
+	var res []dns.RR
+	switch qtype {
+	case dns.TypePTR:
+		addr := dnsutil.ExtractAddressFromReverse(qname)
+		if names := dd.ptrNames(v, addr); len(names) != 0 {
+			res = ptr(qname, dd.hmap.addrTTL(addr), names)
+		}
+	case dns.TypeA:
+		if zone != "" && qname == nsName(zone) {
+			ipv4, _ := dd.nsAddresses(zone)
+			res = a(qname, dd.opts.ttl, ipv4)
+		} else if ips := dd.addresses(v, qname, false); len(ips) != 0 {
+			res = a(qname, dd.hmap.nameTTL(qname), ips)
+		}
+	case dns.TypeAAAA:
+		if zone != "" && qname == nsName(zone) {
+			_, ipv6 := dd.nsAddresses(zone)
+			res = aaaa(qname, dd.opts.ttl, ipv6)
+		} else if ips := dd.addresses(v, qname, true); len(ips) != 0 {
+			res = aaaa(qname, dd.hmap.nameTTL(qname), ips)
+		}
+	case dns.TypeHTTPS, dns.TypeSVCB:
+		res = dd.svcbRecords(v, qname, qtype)
+	case dns.TypeTXT:
+		if dd.opts.explain && zone != "" && strings.HasPrefix(qname, explainPrefix) {
+			res = dd.explainTXT(qname, zone)
+		}
+	case dns.TypeSOA:
+		if qname == zone {
+			res = []dns.RR{dd.soa(zone, dd.hmap.currentSerial())}
+		}
+	case dns.TypeNS:
+		if qname == zone {
+			res = []dns.RR{nsRecord(zone, dd.opts.ttl)}
+		}
+	case dns.TypeNSEC3PARAM:
+		if qname == zone && sgn != nil && sgn.nsec3 {
+			res = []dns.RR{nsec3Param(zone, 0)}
+		}
+	}
+	if zone != "" {
+		res = append(res, dd.hmap.extraRecords(qname, qtype, dd.visibleID(v))...)
+	}
+	return res
+}
+
+// soa returns SOA record of zone with the plugin ttl and negative ttl.
+func (dd *DockerDiscovery) soa(zone string, serial uint32) dns.RR {
+	minttl := dd.opts.ttl
+	if dd.opts.negativeTTL != nil {
+		minttl = *dd.opts.negativeTTL
+	}
+	return soa(zone, dd.opts.ttl, minttl, serial)
+}
+
+// writeMsg signs reply if origin has keys and writes it.
+func (dd *DockerDiscovery) writeMsg(ctx context.Context, state request.Request, sgn *signer, m *dns.Msg) (int, error) {
+	m.Authoritative, m.RecursionAvailable, m.Compress = true, false, true
+	if sgn != nil {
+		m = sgn.sign(state, m, metrics.WithServer(ctx))
+	}
 
 	state.SizeAndDo(m)
 	m = state.Scrub(m)
-	err := w.WriteMsg(m)
+	err := state.W.WriteMsg(m)
 	if err != nil {
 		log.Errorf("error write message: %s", err)
 	}
 	return dns.RcodeSuccess, nil
 }
 
+// nameExists reports whether any record of qname visible in view exists
+// or qname is an empty non-terminal.
+func (dd *DockerDiscovery) nameExists(v *view, qname, zone string) bool {
+	// nameserver of apex NS and its parent
+	if qname == zone || zone != "" && dns.IsSubDomain(qname, nsName(zone)) {
+		return true
+	}
+	if v.custom() {
+		for _, c := range dd.hmap.publishers(qname) {
+			if v.visible(c) {
+				return true
+			}
+		}
+		if len(dd.hmap.allExtraRecords(qname, dd.visibleID(v))) != 0 ||
+			len(dd.viewPTR(v, dnsutil.ExtractAddressFromReverse(qname))) != 0 {
+			return true
+		}
+		return dd.hmap.hasDescendant(qname, dd.visibleID(v))
+	}
+	if dd.hmap.name4.Has(qname) || dd.hmap.name6.Has(qname) || dd.hmap.extra.Has(qname) ||
+		dd.hmap.addr.Has(dnsutil.ExtractAddressFromReverse(qname)) {
+		return true
+	}
+	return dd.hmap.hasDescendant(qname, dd.visibleID(v))
+}
+
 // Name implements plugin.Handler
 func (dd *DockerDiscovery) Name() string {
 	return "docker"
 }
 
 func (dd *DockerDiscovery) scanContainers() error {
-	containers, err := dd.dockerClient.ListContainers(dockerapi.ListContainersOptions{})
+	// stopped containers are listed too, their decisions are kept
+	containers, err := dd.dockerClient.ListContainers(dockerapi.ListContainersOptions{All: true})
 	if err != nil {
 		log.Errorf("[docker] ListContainers: %s", err)
 		return err
 	}
 
+	existing, running := map[string]bool{}, map[string]bool{}
 	for _, apiContainer := range containers {
+		existing[apiContainer.ID] = true
+		switch apiContainer.State {
+		case "created", "exited", "dead", "removing":
+			continue
+		}
 		container, err := dd.dockerClient.InspectContainerWithOptions(dockerapi.InspectContainerOptions{ID: apiContainer.ID})
 		if err != nil {
-			log.Errorf("[docker] Inspect container %s: %s", container.ID[:12], err)
+			log.Errorf("[docker] Inspect container %s: %s", shortID(apiContainer.ID), err)
+			if errors.As(err, new(*dockerapi.NoSuchContainer)) {
+				delete(existing, apiContainer.ID)
+			} else if dd.hmap.ids.Has(apiContainer.ID) {
+				// keep records until the next scan or event
+				running[apiContainer.ID] = true
+			}
 			continue
 		}
+		running[apiContainer.ID] = true
 		dd.updateContainer(container)
 	}
+	dd.pruneStale()
+	dd.pruneGone(existing, running)
 	return nil
 }
 
+// pruneGone removes containers which stopped or were removed while events
+// were not received, i.e. while docker daemon was not available. Decisions
+// are kept only for containers docker still has.
+func (dd *DockerDiscovery) pruneGone(existing, running map[string]bool) {
+	var gone []string
+	dd.hmap.ids.Range(func(id string, _ *ContainerData) bool {
+		if !running[id] {
+			gone = append(gone, id)
+		}
+		return false
+	})
+	for _, id := range gone {
+		dd.removeContainer(id)
+	}
+	var forgotten []string
+	dd.decisions.Range(func(id string, _ *decision) bool {
+		if !existing[id] {
+			forgotten = append(forgotten, id)
+		}
+		return false
+	})
+	for _, id := range forgotten {
+		dd.decisions.Delete(id)
+	}
+}
+
 func (dd *DockerDiscovery) start(stopChan chan struct{}, events chan *dockerapi.APIEvents) {
 	log.Info("[docker] Start event listening")
 	for {
 		select {
 		case <-stopChan:
 			return
-		case msg := <-events:
-			go func(msg *dockerapi.APIEvents) {
-				event := fmt.Sprintf("%s:%s", msg.Type, msg.Action)
-				switch event {
-				case "container:start":
-					container, err := dd.dockerClient.InspectContainerWithOptions(dockerapi.InspectContainerOptions{ID: msg.Actor.ID})
-					if err != nil {
-						log.Errorf("[docker] Event error %s #%s: %s", event, msg.Actor.ID[:12], err)
-						return
-					}
-					if err := dd.updateContainer(container); err != nil {
-						log.Errorf("[docker] update container %s: %s", container.ID[:12], err)
-					}
-				case "container:die":
-					if err := dd.removeContainer(msg.Actor.ID); err != nil {
-						log.Errorf("[docker] Deleting A/AAAA records for container: %s: %s", msg.Actor.ID[:12], err)
-					}
-				case "network:connect":
-					// take a look https://gist.github.com/josefkarasek/be9bac36921f7bc9a61df23451594fbf for example of same event's types attributes
-					container, err := dd.dockerClient.InspectContainerWithOptions(dockerapi.InspectContainerOptions{ID: msg.Actor.Attributes["container"]})
-					if err != nil {
-						log.Errorf("[docker] Event error %s #%s: %s", event, msg.Actor.Attributes["container"][:12], err)
-						return
-					}
-					if err := dd.updateContainerNetworks(container); err != nil {
-						log.Errorf("[docker] update container %s: %s", container.ID[:12], err)
-					}
-				case "network:disconnect":
-					container, err := dd.dockerClient.InspectContainerWithOptions(dockerapi.InspectContainerOptions{ID: msg.Actor.Attributes["container"]})
-					if err != nil {
-						log.Errorf("[docker] Event error %s #%s: %s", event, msg.Actor.Attributes["container"][:12], err)
-						return
-					}
-					if err := dd.updateContainerNetworks(container); err != nil {
-						log.Errorf("[docker] update container %s: %s", container.ID[:12], err)
-					}
-				}
-			}(msg)
+		case msg, ok := <-events:
+			if !ok {
+				return
+			}
+			go dd.handleEvent(msg)
+		}
+	}
+}
+
+// handleEvent updates records of container affected by docker event.
+func (dd *DockerDiscovery) handleEvent(msg *dockerapi.APIEvents) {
+	event := fmt.Sprintf("%s:%s", msg.Type, msg.Action)
+	if msg.Type == "container" || msg.Type == "network" {
+		dd.events.add(msg)
+	}
+	switch event {
+	case "container:start", "container:unpause":
+		dd.inspectAndUpdate(event, msg.Actor.ID)
+	case "container:rename":
+		// drop names derived from the old container name first,
+		// Map.addContainer doesn't remove stale hosts
+		if err := dd.removeContainer(msg.Actor.ID); err != nil {
+			log.Errorf("[docker] Deleting A/AAAA records for container: %s: %s", msg.Actor.ID[:12], err)
+		}
+		dd.inspectAndUpdate(event, msg.Actor.ID)
+	case "container:pause":
+		if !dd.opts.withdrawPaused {
+			return
+		}
+		if err := dd.removeContainer(msg.Actor.ID); err != nil {
+			log.Errorf("[docker] Deleting A/AAAA records for container: %s: %s", msg.Actor.ID[:12], err)
+		}
+	case "container:kill":
+		// kill may only deliver a signal, so check the container state
+		container, err := dd.dockerClient.InspectContainerWithOptions(dockerapi.InspectContainerOptions{ID: msg.Actor.ID})
+		if err != nil {
+			log.Errorf("[docker] Event error %s #%s: %s", event, msg.Actor.ID[:12], err)
+			return
+		}
+		if container.State.Running {
+			return
+		}
+		if err := dd.removeContainer(msg.Actor.ID); err != nil {
+			log.Errorf("[docker] Deleting A/AAAA records for container: %s: %s", msg.Actor.ID[:12], err)
+		}
+	case "container:die", "container:stop":
+		if err := dd.removeContainer(msg.Actor.ID); err != nil {
+			log.Errorf("[docker] Deleting A/AAAA records for container: %s: %s", msg.Actor.ID[:12], err)
+		}
+	case "container:destroy":
+		if err := dd.removeContainer(msg.Actor.ID); err != nil {
+			log.Errorf("[docker] Deleting A/AAAA records for container: %s: %s", msg.Actor.ID[:12], err)
+		}
+		dd.decisions.Delete(msg.Actor.ID)
+	case "network:connect":
+		// take a look https://gist.github.com/josefkarasek/be9bac36921f7bc9a61df23451594fbf for example of same event's types attributes
+		container, err := dd.dockerClient.InspectContainerWithOptions(dockerapi.InspectContainerOptions{ID: msg.Actor.Attributes["container"]})
+		if err != nil {
+			log.Errorf("[docker] Event error %s #%s: %s", event, msg.Actor.Attributes["container"][:12], err)
+			return
+		}
+		if err := dd.updateContainerNetworks(container); err != nil {
+			log.Errorf("[docker] update container %s: %s", container.ID[:12], err)
+		}
+	case "network:disconnect":
+		container, err := dd.dockerClient.InspectContainerWithOptions(dockerapi.InspectContainerOptions{ID: msg.Actor.Attributes["container"]})
+		if err != nil {
+			log.Errorf("[docker] Event error %s #%s: %s", event, msg.Actor.Attributes["container"][:12], err)
+			return
 		}
+		if err := dd.updateContainerNetworks(container); err != nil {
+			log.Errorf("[docker] update container %s: %s", container.ID[:12], err)
+		}
+	}
+}
+
+// inspectAndUpdate fetches actual container state and updates its records.
+func (dd *DockerDiscovery) inspectAndUpdate(event, id string) {
+	container, err := dd.dockerClient.InspectContainerWithOptions(dockerapi.InspectContainerOptions{ID: id})
+	if err != nil {
+		log.Errorf("[docker] Event error %s #%s: %s", event, id[:12], err)
+		return
+	}
+	if err := dd.updateContainer(container); err != nil {
+		log.Errorf("[docker] update container %s: %s", container.ID[:12], err)
 	}
 }
 
-// get ipv4 and ipv6 addresses for container.
-func (dd *DockerDiscovery) getContainerAddresses(container *dockerapi.Container) (ipv4, ipv6 []net.IP, err error) {
+// get ipv4 and ipv6 addresses for container selected by address labels,
+// notes explain rejected label addresses.
+func (dd *DockerDiscovery) getContainerAddresses(container *dockerapi.Container, sel *addressSelection) (ipv4, ipv6 []net.IP, notes []string, err error) {
+	ipv4, ipv6, err = dd.containerAddresses(container, sel)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ipv4, ipv6, notes = sel.apply(ipv4, ipv6)
+	return ipv4, ipv6, notes, nil
+}
+
+// containerAddresses returns addresses of container networks selected by sel.
+func (dd *DockerDiscovery) containerAddresses(container *dockerapi.Container, sel *addressSelection) (ipv4, ipv6 []net.IP, err error) {
 
 	var networkMode string
 
 	for {
-		if container.NetworkSettings.IPAddress != "" {
-			ipv4i := parseIP(container.NetworkSettings.IPAddress)
-			if ipv4i != nil {
-				ipv4 = append(ipv4, ipv4i)
+		// default bridge addresses are ambiguous when networks are selected by label
+		if len(sel.networks) == 0 {
+			if container.NetworkSettings.IPAddress != "" {
+				ipv4i := parseIP(container.NetworkSettings.IPAddress)
+				if ipv4i != nil {
+					ipv4 = append(ipv4, ipv4i)
+				}
 			}
-		}
 
-		if container.NetworkSettings.GlobalIPv6Address != "" {
-			ipv6i := parseIP(container.NetworkSettings.GlobalIPv6Address)
-			if ipv6i != nil {
-				ipv6 = append(ipv6, ipv6i)
+			if container.NetworkSettings.GlobalIPv6Address != "" {
+				ipv6i := parseIP(container.NetworkSettings.GlobalIPv6Address)
+				if ipv6i != nil {
+					ipv6 = append(ipv6, ipv6i)
+				}
 			}
 		}
 
 		networkMode = container.HostConfig.NetworkMode
 
-		// if networkMode == "host" {
-		// 	log.Infof("[docker] Container %s uses host network", container.ID[:12])
-		// }
+		if networkMode == hostNetworkMode {
+			if len(dd.opts.hostIPv4) == 0 && len(dd.opts.hostIPv6) == 0 {
+				err = fmt.Errorf("[docker] container %s uses host network, host_network_ip is not set: %s",
+					container.ID[:12], normalizeContainerName(container))
+				return
+			}
+			ipv4 = append(ipv4, dd.opts.hostIPv4...)
+			ipv6 = append(ipv6, dd.opts.hostIPv6...)
+			return
+		}
 
 		if strings.HasPrefix(networkMode, "container:") {
 			otherID := container.HostConfig.NetworkMode[len("container:"):]
@@ -239,6 +513,13 @@ func (dd *DockerDiscovery) getContainerAddresses(container *dockerapi.Container)
 		}
 	}
 
+	if dd.opts.publishMode == publishModeHost {
+		pub4, pub6 := dd.publishedAddresses(container)
+		if len(pub4) != 0 || len(pub6) != 0 {
+			return pub4, pub6, nil
+		}
+	}
+
 	var (
 		network dockerapi.ContainerNetwork
 		netName string
@@ -246,7 +527,7 @@ func (dd *DockerDiscovery) getContainerAddresses(container *dockerapi.Container)
 	)
 
 	for netName, network = range container.NetworkSettings.Networks {
-		if !dd.permittedNetwork(netName) {
+		if !dd.permittedNetwork(netName) || !sel.network(netName) {
 			continue
 		}
 		addressesFromNetwork(network, &ipv4, &ipv6)
@@ -255,6 +536,9 @@ func (dd *DockerDiscovery) getContainerAddresses(container *dockerapi.Container)
 
 	if !ok { // sometime while "network:disconnect" event fire
 		err = fmt.Errorf("[docker] no permitted networks of container %s: %s", container.ID[:12], normalizeContainerName(container))
+		if len(sel.networks) != 0 {
+			err = fmt.Errorf("[docker] no permitted networks %v of container %s: %s", sel.networks, container.ID[:12], normalizeContainerName(container))
+		}
 	}
 
 	return
@@ -277,15 +561,38 @@ func addressesFromNetwork(network dockerapi.ContainerNetwork, ipv4, ipv6 *[]net.
 
 func (dd *DockerDiscovery) updateContainer(container *dockerapi.Container) error {
 	c, err := dd.parseContainer(container)
-	if err != nil || c.forceDisabled || (!dd.opts.enabledByDefault && !c.enabled) {
+	enabled, r := dd.filterContainer(container, c)
+	published := false
+	switch {
+	case errors.As(err, new(*labelError)):
+		r = reason{reasonInvalidLabel, err.Error()}
+	case err != nil:
+		r = reason{reasonNoAddress, err.Error()}
+	case !enabled:
+	case !container.State.Running:
+		r = reason{reasonNotRunning, "container is not running"}
+	case dd.opts.withdrawPaused && container.State.Paused:
+		r = reason{reasonPaused, "container is paused"}
+	case len(c.ipv4) == 0 && len(c.ipv6) == 0:
+		r = reason{reasonNoAddress, "container has no ip addresses"}
+	case len(c.hosts) == 0:
+		r = reason{reasonNoHosts, "no host names derived for container"}
+	default:
+		published = true
+	}
+	dd.decide(c, published, r)
+
+	if !published {
+		log.Infof("[docker] skip container %s (%s): %s",
+			normalizeContainerName(container), container.ID[:12], r)
 		if dd.hmap.ids.Has(c.id) {
 			dd.hmap.removeContainer(c.id)
 		}
 		return err
 	}
 
-	log.Infof("[docker] add entry of container %s (%s). IP: %v. Hosts: %v",
-		normalizeContainerName(container), container.ID[:12], c.ipv4, c.hosts)
+	log.Infof("[docker] add entry of container %s (%s), %s. IP: %v. Hosts: %v",
+		normalizeContainerName(container), container.ID[:12], r, c.ipv4, c.hosts)
 	dd.hmap.addContainer(c)
 	return nil
 }
@@ -295,6 +602,7 @@ func (dd *DockerDiscovery) removeContainer(containerID string) error {
 	if !ok {
 		return nil
 	}
+	dd.withdraw(containerID, reason{reasonRemoved, "container records are removed"})
 	dd.hmap.removeContainer(containerID)
 	return nil
 }
@@ -304,12 +612,18 @@ func (dd *DockerDiscovery) updateContainerNetworks(container *dockerapi.Containe
 	if !ok {
 		return nil
 	}
-	ipv4, ipv6, err := dd.getContainerAddresses(container)
+	sel, err := parseAddressSelection(container.Config.Labels)
+	if err != nil {
+		return &labelError{err}
+	}
+	ipv4, ipv6, _, err := dd.getContainerAddresses(container, sel)
 	if err != nil {
 		return err
 	}
-	c.ipv4 = ipv4
-	c.ipv6 = ipv6
-	dd.hmap.addContainer(c)
+	// stored container data is replaced, not modified
+	nc := *c
+	nc.ipv4 = ipv4
+	nc.ipv6 = ipv6
+	dd.hmap.addContainer(&nc)
 	return nil
 }
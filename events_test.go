@@ -0,0 +1,281 @@
+package dockerdns
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	dockerapi "github.com/fsouza/go-dockerclient"
+)
+
+// stubClient serves containers from memory instead of docker daemon.
+type stubClient struct {
+	mu         sync.Mutex
+	containers map[string]*dockerapi.Container
+	err        error // returned by every call when set
+	listeners  int
+	vanished   map[string]bool // listed, but removed before inspect
+}
+
+func newStubClient(containers ...*dockerapi.Container) *stubClient {
+	s := &stubClient{containers: map[string]*dockerapi.Container{}}
+	for _, c := range containers {
+		s.containers[c.ID] = c
+	}
+	return s
+}
+
+func (s *stubClient) ListContainers(opts dockerapi.ListContainersOptions) ([]dockerapi.APIContainers, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	var res []dockerapi.APIContainers
+	for id, c := range s.containers {
+		if label := opts.Filters["label"]; len(label) != 0 {
+			if _, ok := c.Config.Labels[label[0]]; !ok {
+				continue
+			}
+		}
+		state := "exited"
+		if c.State.Running {
+			state = "running"
+		}
+		res = append(res, dockerapi.APIContainers{ID: id, State: state})
+	}
+	return res, nil
+}
+
+func (s *stubClient) InspectContainerWithOptions(opts dockerapi.InspectContainerOptions) (*dockerapi.Container, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	c, ok := s.containers[opts.ID]
+	if !ok || s.vanished[opts.ID] {
+		return nil, &dockerapi.NoSuchContainer{ID: opts.ID}
+	}
+	return c, nil
+}
+
+func (s *stubClient) AddEventListener(chan<- *dockerapi.APIEvents) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.listeners++
+	return nil
+}
+
+func (s *stubClient) RemoveEventListener(chan *dockerapi.APIEvents) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listeners == 0 {
+		return errors.New("listener is not registered")
+	}
+	s.listeners--
+	return nil
+}
+
+func (s *stubClient) set(c *dockerapi.Container) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.containers[c.ID] = c
+}
+
+// fail makes every call but RemoveEventListener return err, nil restores the daemon.
+func (s *stubClient) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// testContainer returns container attached to network bridge with address ip.
+func testContainer(id, name, ip string) *dockerapi.Container {
+	return &dockerapi.Container{
+		ID:         id,
+		Name:       "/" + name,
+		Config:     &dockerapi.Config{Labels: map[string]string{}},
+		HostConfig: &dockerapi.HostConfig{NetworkMode: "bridge"},
+		State:      dockerapi.State{Running: true},
+		NetworkSettings: &dockerapi.NetworkSettings{
+			Networks: map[string]dockerapi.ContainerNetwork{"bridge": {IPAddress: ip}},
+		},
+	}
+}
+
+func TestHandleEvent(t *testing.T) {
+	const id = "0123456789abcdef"
+	running := testContainer(id, "web", "172.17.0.2")
+	stopped := testContainer(id, "web", "172.17.0.2")
+	stopped.State.Running = false
+	paused := testContainer(id, "web", "172.17.0.2")
+	paused.State.Paused = true
+	renamed := testContainer(id, "api", "172.17.0.2")
+
+	tests := []struct {
+		name           string
+		withdrawPaused bool
+		registered     bool                 // container is published before event
+		inspect        *dockerapi.Container // container state after event
+		event          string
+		want           []string
+		wantGone       []string
+	}{
+		{name: "start", inspect: running, event: "start", want: []string{"web.loc."}},
+		{name: "die", registered: true, inspect: stopped, event: "die", wantGone: []string{"web.loc."}},
+		{name: "stop", registered: true, inspect: stopped, event: "stop", wantGone: []string{"web.loc."}},
+		{name: "kill while running", registered: true, inspect: running, event: "kill", want: []string{"web.loc."}},
+		{name: "kill stopped", registered: true, inspect: stopped, event: "kill", wantGone: []string{"web.loc."}},
+		{name: "destroy", registered: true, inspect: stopped, event: "destroy", wantGone: []string{"web.loc."}},
+		{name: "pause kept", registered: true, inspect: paused, event: "pause", want: []string{"web.loc."}},
+		{name: "pause withdrawn", withdrawPaused: true, registered: true, inspect: paused, event: "pause", wantGone: []string{"web.loc."}},
+		{name: "unpause", withdrawPaused: true, inspect: running, event: "unpause", want: []string{"web.loc."}},
+		{name: "rename", registered: true, inspect: renamed, event: "rename", want: []string{"api.loc."}, wantGone: []string{"web.loc."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dd := NewDockerDiscovery("")
+			dd.Origins = []string{"loc."}
+			dd.addRZones()
+			dd.opts.byDomain = true
+			dd.opts.enabledByDefault = true
+			dd.opts.withdrawPaused = tt.withdrawPaused
+			client := newStubClient(running)
+			dd.dockerClient = client
+			if tt.registered {
+				if err := dd.updateContainer(running); err != nil {
+					t.Fatalf("updateContainer() error = %v", err)
+				}
+			}
+			client.set(tt.inspect)
+
+			dd.handleEvent(&dockerapi.APIEvents{Type: "container", Action: tt.event, Actor: dockerapi.APIActor{ID: id}})
+
+			for _, name := range tt.want {
+				if !dd.hmap.name4.Has(name) {
+					t.Errorf("%s is not published after %s", name, tt.event)
+				}
+			}
+			for _, name := range tt.wantGone {
+				if dd.hmap.name4.Has(name) {
+					t.Errorf("%s is published after %s", name, tt.event)
+				}
+			}
+			if tt.event == "destroy" && dd.decisions.Has(id) {
+				t.Errorf("decision of destroyed container is kept")
+			}
+		})
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	self := testContainer("fedcba9876543210", "coredns", "")
+	self.Config.Labels[dockerIdentityLabel] = ""
+	self.NetworkSettings.Networks = map[string]dockerapi.ContainerNetwork{"dns": {IPAddress: "172.30.0.53"}}
+	web := testContainer("0123456789abcdef", "web", "172.17.0.2")
+	web.NetworkSettings.Networks["dns"] = dockerapi.ContainerNetwork{IPAddress: "172.30.0.2"}
+
+	dd := NewDockerDiscovery("")
+	dd.Origins = []string{"loc."}
+	dd.addRZones()
+	dd.opts.byDomain = true
+	dd.opts.enabledByDefault = true
+	dd.opts.ownNetworks = true
+	dd.opts.reconnectInterval = 10 * time.Millisecond
+	client := newStubClient(self, web)
+	client.fail(errors.New("daemon is not available"))
+	dd.dockerClient = client
+
+	stopChan := make(chan struct{})
+	eventChan := make(chan *dockerapi.APIEvents)
+	if err := dd.connect(eventChan); err == nil {
+		t.Fatalf("connect() error = nil, want daemon error")
+	}
+	go dd.reconnect(stopChan, eventChan)
+	time.Sleep(30 * time.Millisecond)
+	client.fail(nil)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !dd.isConnected() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !dd.isConnected() {
+		t.Fatalf("plugin is not connected after daemon is available")
+	}
+	// networks of own container are found after reconnect
+	if !reflect.DeepEqual(dd.networks(), []string{"dns"}) {
+		t.Errorf("networks = %v, want [dns]", dd.networks())
+	}
+	ips, _ := dd.hmap.name4.Load("web.loc.")
+	if !reflect.DeepEqual(ipStrings(ips), []string{"172.30.0.2"}) {
+		t.Errorf("web.loc. = %v, want address of own network only", ips)
+	}
+
+	dd.shutdown(stopChan, eventChan)
+	if client.listeners != 0 {
+		t.Errorf("%d event listeners are left after shutdown", client.listeners)
+	}
+	if err := dd.connect(eventChan); err == nil {
+		t.Errorf("connect() after shutdown error = nil, want error")
+	}
+}
+
+func TestScanVanishedContainer(t *testing.T) {
+	web := testContainer("0123456789abcdef", "web", "172.17.0.2")
+	db := testContainer("fedcba9876543210", "db", "172.17.0.3")
+	dd := NewDockerDiscovery("")
+	dd.Origins = []string{"loc."}
+	dd.addRZones()
+	dd.opts.byDomain = true
+	dd.opts.enabledByDefault = true
+	client := newStubClient(web, db)
+	client.vanished = map[string]bool{db.ID: true}
+	dd.dockerClient = client
+
+	// container removed between list and inspect is skipped
+	if err := dd.scanContainers(); err != nil {
+		t.Fatalf("scanContainers() error = %v", err)
+	}
+	if !dd.hmap.name4.Has("web.loc.") || dd.hmap.name4.Has("db.loc.") {
+		t.Errorf("scanned names are wrong, want web.loc. only")
+	}
+}
+
+func TestScanPrunesGone(t *testing.T) {
+	web := testContainer("0123456789abcdef", "web", "172.17.0.2")
+	db := testContainer("fedcba9876543210", "db", "172.17.0.3")
+	old := testContainer("00112233445566778899", "old", "172.17.0.4")
+	dd := NewDockerDiscovery("")
+	dd.Origins = []string{"loc."}
+	dd.addRZones()
+	dd.opts.byDomain = true
+	dd.opts.enabledByDefault = true
+	for _, c := range []*dockerapi.Container{web, db, old} {
+		if err := dd.updateContainer(c); err != nil {
+			t.Fatalf("updateContainer() error = %v", err)
+		}
+	}
+
+	// while daemon was down db stopped and old was removed
+	stopped := testContainer(db.ID, "db", "172.17.0.3")
+	stopped.State.Running = false
+	dd.dockerClient = newStubClient(web, stopped)
+	if err := dd.scanContainers(); err != nil {
+		t.Fatalf("scanContainers() error = %v", err)
+	}
+	if !dd.hmap.name4.Has("web.loc.") || dd.hmap.name4.Has("db.loc.") || dd.hmap.name4.Has("old.loc.") {
+		t.Errorf("names after scan are wrong, want web.loc. only")
+	}
+	if d, ok := dd.decisions.Load(db.ID); !ok || d.Published || d.Reason.Code != reasonRemoved {
+		t.Errorf("decision of stopped container = %+v, want removed", d)
+	}
+	if dd.decisions.Has(old.ID) || len(dd.explain("old")) != 0 {
+		t.Errorf("decision of removed container is kept")
+	}
+}
@@ -0,0 +1,184 @@
+package dockerdns
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	exportHosts = "hosts"
+	exportZone  = "zone"
+
+	// exportDelay debounces file rewrites
+	exportDelay = time.Second
+	// exportMaxDelay bounds the wait when changes don't settle
+	exportMaxDelay = 10 * time.Second
+)
+
+// fileExporter writes records to a hosts-format or RFC 1035 zone file.
+// File is rewritten atomically after changes settle for exportDelay,
+// but not later than exportMaxDelay after the first pending change.
+type fileExporter struct {
+	path     string
+	format   string
+	zone     string // origin of zone file
+	delay    time.Duration
+	maxDelay time.Duration
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	pending time.Time // time of the first change not written yet
+	stopped bool
+	writing sync.WaitGroup
+}
+
+func newFileExporter(path, format, zone string) *fileExporter {
+	return &fileExporter{
+		path:     path,
+		format:   format,
+		zone:     zone,
+		delay:    exportDelay,
+		maxDelay: exportMaxDelay,
+	}
+}
+
+// schedule postpones write for delay since the latest change, up to maxDelay
+// since the first pending one, apex returns SOA and NS records of zone file.
+func (e *fileExporter) schedule(m *Map, apex func(zone string, serial uint32) []dns.RR) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stopped {
+		return
+	}
+	now := time.Now()
+	if e.pending.IsZero() {
+		e.pending = now
+	}
+	wait := e.delay
+	if deadline := e.pending.Add(e.maxDelay); now.Add(wait).After(deadline) {
+		wait = deadline.Sub(now)
+	}
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	e.timer = time.AfterFunc(wait, func() {
+		e.mu.Lock()
+		if e.stopped {
+			e.mu.Unlock()
+			return
+		}
+		e.pending = time.Time{}
+		e.writing.Add(1)
+		e.mu.Unlock()
+		defer e.writing.Done()
+		if err := e.write(m, apex); err != nil {
+			log.Errorf("[docker] export %s file %s: %s", e.format, e.path, err)
+		}
+	})
+}
+
+// stop cancels scheduled write and waits for the running one.
+func (e *fileExporter) stop() {
+	e.mu.Lock()
+	e.stopped = true
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	e.mu.Unlock()
+	e.writing.Wait()
+}
+
+func (e *fileExporter) write(m *Map, apex func(zone string, serial uint32) []dns.RR) error {
+	serial, records := m.records()
+	var data []byte
+	switch e.format {
+	case exportHosts:
+		data = renderHosts(records)
+	case exportZone:
+		data = renderZone(e.zone, apex(e.zone, serial), records)
+	default:
+		return fmt.Errorf("unknown export format %s", e.format)
+	}
+	return writeFileAtomic(e.path, data)
+}
+
+// renderHosts returns records in /etc/hosts format, one line per address.
+func renderHosts(records []dns.RR) []byte {
+	hosts := map[string][]string{}
+	for _, rr := range records {
+		var ip string
+		switch r := rr.(type) {
+		case *dns.A:
+			ip = r.A.String()
+		case *dns.AAAA:
+			ip = r.AAAA.String()
+		default:
+			continue
+		}
+		hosts[ip] = append(hosts[ip], strings.TrimSuffix(rr.Header().Name, "."))
+	}
+	ips := make([]string, 0, len(hosts))
+	for ip := range hosts {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	buf := &bytes.Buffer{}
+	buf.WriteString("# generated by coredns docker plugin\n")
+	for _, ip := range ips {
+		names := hosts[ip]
+		sort.Strings(names)
+		fmt.Fprintf(buf, "%s\t%s\n", ip, strings.Join(names, " "))
+	}
+	return buf.Bytes()
+}
+
+// renderZone returns records of zone in RFC 1035 master file format.
+func renderZone(zone string, apex []dns.RR, records []dns.RR) []byte {
+	lines := []string{}
+	for _, rr := range records {
+		if !dns.IsSubDomain(zone, rr.Header().Name) {
+			continue
+		}
+		lines = append(lines, rr.String())
+	}
+	sort.Strings(lines)
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "$ORIGIN %s\n", zone)
+	for _, rr := range apex {
+		fmt.Fprintf(buf, "%s\n", rr.String())
+	}
+	for _, l := range lines {
+		buf.WriteString(l)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// writeFileAtomic replaces file with a temporary one written in the same directory.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
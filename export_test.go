@@ -0,0 +1,96 @@
+package dockerdns
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func testApex(zone string, serial uint32) []dns.RR {
+	return []dns.RR{soa(zone, 30, 30, serial), nsRecord(zone, 30)}
+}
+
+func TestFileExporter(t *testing.T) {
+	dir := t.TempDir()
+	m := newTestMap(conflictMerge)
+	m.addContainer(&ContainerData{
+		id:    "web",
+		hosts: []string{"web.loc.", "www.loc."},
+		ipv4:  []net.IP{parseIP("172.28.0.2")},
+		ipv6:  []net.IP{parseIP("fd00::2")},
+	})
+
+	hosts := newFileExporter(filepath.Join(dir, "hosts"), exportHosts, "")
+	if err := hosts.write(m, testApex); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	data, err := os.ReadFile(hosts.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"172.28.0.2\tweb.loc www.loc\n", "fd00::2\tweb.loc www.loc\n"} {
+		if !strings.Contains(string(data), line) {
+			t.Errorf("hosts file = %q, want line %q", data, line)
+		}
+	}
+
+	zone := newFileExporter(filepath.Join(dir, "db.loc"), exportZone, "loc.")
+	if err := zone.write(m, testApex); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	f, err := os.Open(zone.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zp := dns.NewZoneParser(f, "", zone.path)
+	count := 0
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if rr.Header().Ttl != 30 {
+			t.Errorf("record %s, want ttl 30", rr)
+		}
+		count++
+	}
+	if err := zp.Err(); err != nil {
+		t.Fatalf("zone file parse error = %v", err)
+	}
+	// SOA, NS, 2 A and 2 AAAA records
+	if count != 6 {
+		t.Errorf("zone file has %d records, want 6", count)
+	}
+}
+
+func TestFileExporterMaxDelay(t *testing.T) {
+	m := newTestMap(conflictMerge)
+	m.addContainer(&ContainerData{id: "web", hosts: []string{"web.loc."}, ipv4: []net.IP{parseIP("172.28.0.2")}})
+	e := newFileExporter(filepath.Join(t.TempDir(), "hosts"), exportHosts, "")
+	e.delay = 50 * time.Millisecond
+	e.maxDelay = 150 * time.Millisecond
+
+	// changes never settle for delay
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		e.schedule(m, testApex)
+		if _, err := os.Stat(e.path); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	e.stop()
+	if _, err := os.Stat(e.path); err != nil {
+		t.Fatalf("file is not written under churn: %v", err)
+	}
+	if err := os.Remove(e.path); err != nil {
+		t.Fatal(err)
+	}
+	e.schedule(m, testApex)
+	time.Sleep(100 * time.Millisecond)
+	if _, err := os.Stat(e.path); err == nil {
+		t.Errorf("file is written after stop()")
+	}
+}
@@ -0,0 +1,146 @@
+package dockerdns
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	dockerapi "github.com/fsouza/go-dockerclient"
+)
+
+const (
+	filterName    = "name"
+	filterImage   = "image"
+	filterProject = "project"
+	filterLabel   = "label"
+)
+
+// containerFilter matches one container attribute against a pattern.
+// Pattern is a glob (see path.Match) or a regular expression when
+// it is enclosed in slashes, i.e. /^web-[0-9]+$/
+type containerFilter struct {
+	expr  string
+	field string
+	key   string // label key, used only by label filters
+	match func(string) bool
+}
+
+// newContainerFilter parses filter expression of the form
+// name=PATTERN, image=PATTERN, project=PATTERN, label=KEY or label=KEY=PATTERN
+func newContainerFilter(expr string) (*containerFilter, error) {
+	field, pattern, ok := strings.Cut(expr, "=")
+	if !ok || pattern == "" {
+		return nil, fmt.Errorf("invalid filter expression: %s", expr)
+	}
+	f := &containerFilter{
+		expr:  expr,
+		field: field,
+	}
+	switch field {
+	case filterName, filterImage, filterProject:
+	case filterLabel:
+		key, value, ok := strings.Cut(pattern, "=")
+		f.key = key
+		if !ok {
+			// label=KEY matches any container having label KEY
+			f.match = func(string) bool { return true }
+			return f, nil
+		}
+		if value == "" {
+			return nil, fmt.Errorf("invalid label filter expression: %s", expr)
+		}
+		pattern = value
+	default:
+		return nil, fmt.Errorf("unknown filter field '%s' in expression: %s", field, expr)
+	}
+	match, err := patternMatcher(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter expression %s: %s", expr, err)
+	}
+	f.match = match
+	return f, nil
+}
+
+func patternMatcher(pattern string) (func(string) bool, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+	// check pattern syntax once
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	return func(s string) bool {
+		ok, _ := path.Match(pattern, s)
+		return ok
+	}, nil
+}
+
+// Match reports whether container satisfies the filter.
+func (f *containerFilter) Match(container *dockerapi.Container) bool {
+	return f.matchFields(normalizeContainerName(container), container.Config.Image, container.Config.Labels)
+}
+
+// MatchData reports whether parsed container satisfies the filter.
+func (f *containerFilter) MatchData(c *ContainerData) bool {
+	return f.matchFields(c.name, c.image, c.labels)
+}
+
+func (f *containerFilter) matchFields(name, image string, labels map[string]string) bool {
+	switch f.field {
+	case filterName:
+		return f.match(name)
+	case filterImage:
+		return f.match(image)
+	case filterProject:
+		project, ok := labels[dockerProjectLabel]
+		return ok && f.match(project)
+	case filterLabel:
+		value, ok := labels[f.key]
+		return ok && f.match(value)
+	}
+	return false
+}
+
+func (f *containerFilter) String() string {
+	return f.expr
+}
+
+// matchFilters returns the first filter matched by container.
+func matchFilters(filters []*containerFilter, container *dockerapi.Container) *containerFilter {
+	for _, f := range filters {
+		if f.Match(container) {
+			return f
+		}
+	}
+	return nil
+}
+
+// filterContainer decides whether container is allowed to be published
+// by include/exclude filters, labels, enabled projects and enabled_by_default option.
+// Exclude filters take precedence over all other rules.
+func (dd *DockerDiscovery) filterContainer(container *dockerapi.Container, c *ContainerData) (bool, reason) {
+	if c.forceDisabled {
+		return false, reason{reasonDisabledByLabel, "disabled by label " + dockerEnableLabel}
+	}
+	if f := matchFilters(dd.opts.exclude, container); f != nil {
+		return false, reason{reasonExcluded, "excluded by filter " + f.String()}
+	}
+	if c.enabled {
+		return true, reason{reasonEnabledByLabel, "enabled by label " + dockerEnableLabel}
+	}
+	if f := matchFilters(dd.opts.include, container); f != nil {
+		return true, reason{reasonIncluded, "included by filter " + f.String()}
+	}
+	if c.project != "" && indexOf(dd.opts.enabledProjects, c.project) >= 0 {
+		return true, reason{reasonEnabledByProject, "enabled by project " + c.project}
+	}
+	if dd.opts.enabledByDefault {
+		return true, reason{reasonEnabledByDefault, "enabled by default"}
+	}
+	return false, reason{reasonNotEnabled, "not enabled"}
+}
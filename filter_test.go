@@ -0,0 +1,92 @@
+package dockerdns
+
+import (
+	"testing"
+)
+
+func TestContainerFilter(t *testing.T) {
+	c := setupTestContainer(t)
+	tests := []struct {
+		name    string
+		expr    string
+		want    bool
+		wantErr bool
+	}{
+		{name: "name glob", expr: "name=who*", want: true},
+		{name: "name glob no match", expr: "name=web*", want: false},
+		{name: "name regex", expr: "name=/^who[a-z]+$/", want: true},
+		{name: "image glob", expr: "image=traefik/*", want: true},
+		{name: "image regex no match", expr: "image=/^nginx/", want: false},
+		{name: "project", expr: "project=dns-proxy", want: true},
+		{name: "label exists", expr: "label=" + dockerServiceLabel, want: true},
+		{name: "label value", expr: "label=" + dockerServiceLabel + "=who*", want: true},
+		{name: "label value no match", expr: "label=" + dockerServiceLabel + "=/^web/", want: false},
+		{name: "label absent", expr: "label=env=dev", want: false},
+		{name: "unknown field", expr: "ports=80", wantErr: true},
+		{name: "empty pattern", expr: "name=", wantErr: true},
+		{name: "bad regex", expr: "name=/[/", wantErr: true},
+		{name: "bad glob", expr: "name=[", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newContainerFilter(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newContainerFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := f.Match(c); got != tt.want {
+				t.Errorf("containerFilter.Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterContainer(t *testing.T) {
+	c := setupTestContainer(t)
+	filters := func(exprs ...string) []*containerFilter {
+		res := []*containerFilter{}
+		for _, e := range exprs {
+			f, err := newContainerFilter(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res = append(res, f)
+		}
+		return res
+	}
+	tests := []struct {
+		name    string
+		opts    dnsControlOpts
+		enabled bool
+		want    bool
+	}{
+		{name: "not enabled", want: false},
+		{name: "enabled by default", opts: dnsControlOpts{enabledByDefault: true}, want: true},
+		{name: "enabled by label", enabled: true, want: true},
+		{name: "included", opts: dnsControlOpts{include: filters("name=whoami")}, want: true},
+		{
+			name:    "exclude wins over label",
+			opts:    dnsControlOpts{exclude: filters("image=traefik/*")},
+			enabled: true,
+			want:    false,
+		},
+		{
+			name: "exclude wins over include",
+			opts: dnsControlOpts{include: filters("name=whoami"), exclude: filters("project=dns-*")},
+			want: false,
+		},
+		{name: "enabled project", opts: dnsControlOpts{enabledProjects: []string{"other", "dns-proxy"}}, want: true},
+		{name: "other project", opts: dnsControlOpts{enabledProjects: []string{"other"}}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dd := &DockerDiscovery{opts: tt.opts}
+			got, reason := dd.filterContainer(c, &ContainerData{enabled: tt.enabled, project: "dns-proxy"})
+			if got != tt.want {
+				t.Errorf("filterContainer() = %v (%s), want %v", got, reason, tt.want)
+			}
+		})
+	}
+}
@@ -8,6 +8,7 @@ require (
 	github.com/fsouza/go-dockerclient v1.9.7
 	github.com/mhmtszr/concurrent-swiss-map v0.0.9
 	github.com/miekg/dns v1.1.54
+	github.com/prometheus/client_golang v1.15.1
 	github.com/stretchr/testify v1.8.2
 )
 
@@ -39,11 +40,11 @@ require (
 	github.com/opentracing/opentracing-go v1.2.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/prometheus/client_golang v1.15.1 // indirect
 	github.com/prometheus/client_model v0.4.0 // indirect
 	github.com/prometheus/common v0.43.0 // indirect
 	github.com/prometheus/procfs v0.9.0 // indirect
 	github.com/sirupsen/logrus v1.9.0 // indirect
+	golang.org/x/crypto v0.1.0 // indirect
 	golang.org/x/mod v0.10.0 // indirect
 	golang.org/x/net v0.9.0 // indirect
 	golang.org/x/sys v0.8.0 // indirect
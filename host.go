@@ -0,0 +1,156 @@
+package dockerdns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	dockerapi "github.com/fsouza/go-dockerclient"
+)
+
+const (
+	hostNetworkMode = "host"
+
+	publishModeContainer = "container"
+	publishModeHost      = "host"
+)
+
+// hostAddresses returns ipv4 and ipv6 addresses of host interfaces, interfaces
+// of docker networks, loopback and link-local addresses are skipped.
+func hostAddresses() (ipv4, ipv6 []net.IP, err error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, iface := range ifaces {
+		if dockerInterface(iface.Name) {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, nil, err
+		}
+		ipv4, ipv6 = appendHostAddresses(ipv4, ipv6, addrs)
+	}
+	if len(ipv4) == 0 && len(ipv6) == 0 {
+		return nil, nil, fmt.Errorf("no usable host interface addresses found")
+	}
+	return ipv4, ipv6, nil
+}
+
+// dockerInterface reports whether interface is created by docker for its networks,
+// addresses of such interfaces are not reachable from other hosts.
+func dockerInterface(name string) bool {
+	return name == "docker0" || name == "docker_gwbridge" ||
+		strings.HasPrefix(name, "br-") || strings.HasPrefix(name, "veth")
+}
+
+// appendHostAddresses appends addresses of interface except loopback and link-local ones.
+func appendHostAddresses(ipv4, ipv6 []net.IP, addrs []net.Addr) ([]net.IP, []net.IP) {
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipnet.IP
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+			continue
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ipv4 = append(ipv4, ip4)
+		} else {
+			ipv6 = append(ipv6, ip)
+		}
+	}
+	return ipv4, ipv6
+}
+
+// splitIPs parses list of literal ip addresses into ipv4 and ipv6 lists.
+func splitIPs(addrs []string) (ipv4, ipv6 []net.IP, err error) {
+	for _, addr := range addrs {
+		ip := parseIP(addr)
+		if ip == nil {
+			return nil, nil, fmt.Errorf("invalid ip address: %s", addr)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ipv4 = append(ipv4, ip4)
+		} else {
+			ipv6 = append(ipv6, ip)
+		}
+	}
+	return ipv4, ipv6, nil
+}
+
+// publishedAddresses returns host addresses container ports are bound to.
+// Wildcard bindings are replaced with external addresses from publish_mode
+// directive or, if those are not set, with host_network_ip addresses.
+func (dd *DockerDiscovery) publishedAddresses(container *dockerapi.Container) (ipv4, ipv6 []net.IP) {
+	ext4, ext6 := dd.externalAddresses()
+	return bindingAddresses(portBindings(container), ext4, ext6)
+}
+
+// externalAddresses returns addresses replacing wildcard port bindings.
+func (dd *DockerDiscovery) externalAddresses() (ipv4, ipv6 []net.IP) {
+	if len(dd.opts.publishIPv4) == 0 && len(dd.opts.publishIPv6) == 0 {
+		return dd.opts.hostIPv4, dd.opts.hostIPv6
+	}
+	return dd.opts.publishIPv4, dd.opts.publishIPv6
+}
+
+// portBindings returns host ip addresses of all published ports of container.
+func portBindings(container *dockerapi.Container) []string {
+	var res []string
+	for _, bindings := range container.NetworkSettings.Ports {
+		for _, b := range bindings {
+			res = append(res, b.HostIP)
+		}
+	}
+	return res
+}
+
+// wildcardBinding reports whether any port is bound to all host addresses.
+func wildcardBinding(bindings []string) bool {
+	for _, hostIP := range bindings {
+		if hostIP == "" || hostIP == net.IPv4zero.String() || hostIP == net.IPv6unspecified.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// bindingAddresses returns unique addresses of port bindings,
+// wildcard bindings are replaced with ext4 and ext6 addresses.
+func bindingAddresses(bindings []string, ext4, ext6 []net.IP) (ipv4, ipv6 []net.IP) {
+	set := map[string]struct{}{}
+	add := func(ip net.IP) {
+		key := ip.String()
+		if _, ok := set[key]; ok {
+			return
+		}
+		set[key] = struct{}{}
+		if ip4 := ip.To4(); ip4 != nil {
+			ipv4 = append(ipv4, ip4)
+		} else {
+			ipv6 = append(ipv6, ip)
+		}
+	}
+	for _, hostIP := range bindings {
+		var ips []net.IP
+		switch hostIP {
+		case "":
+			ips = append(append(ips, ext4...), ext6...)
+		case net.IPv4zero.String():
+			ips = ext4
+		case net.IPv6unspecified.String():
+			ips = ext6
+		default:
+			if ip := parseIP(hostIP); ip != nil {
+				ips = []net.IP{ip}
+			}
+		}
+		for _, ip := range ips {
+			add(ip)
+		}
+	}
+	return ipv4, ipv6
+}
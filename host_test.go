@@ -0,0 +1,151 @@
+package dockerdns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	dockerapi "github.com/fsouza/go-dockerclient"
+)
+
+func TestPublishedAddresses(t *testing.T) {
+	ext4, ext6, err := splitIPs([]string{"192.168.1.10", "fd00::10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		opts     dnsControlOpts
+		bindings []dockerapi.PortBinding
+		want4    []net.IP
+		want6    []net.IP
+	}{
+		{
+			name:     "explicit host ip",
+			bindings: []dockerapi.PortBinding{{HostIP: "127.0.0.53", HostPort: "53"}},
+			want4:    []net.IP{parseIP("127.0.0.53")},
+		},
+		{
+			name:     "wildcard ipv4",
+			opts:     dnsControlOpts{publishIPv4: ext4, publishIPv6: ext6},
+			bindings: []dockerapi.PortBinding{{HostIP: "0.0.0.0", HostPort: "80"}, {HostIP: "::", HostPort: "80"}},
+			want4:    ext4,
+			want6:    ext6,
+		},
+		{
+			name:     "wildcard falls back to host_network_ip",
+			opts:     dnsControlOpts{hostIPv4: ext4},
+			bindings: []dockerapi.PortBinding{{HostIP: "0.0.0.0", HostPort: "80"}},
+			want4:    ext4,
+		},
+		{
+			name:     "wildcard without external addresses",
+			bindings: []dockerapi.PortBinding{{HostIP: "0.0.0.0", HostPort: "80"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dd := &DockerDiscovery{opts: tt.opts}
+			container := &dockerapi.Container{
+				NetworkSettings: &dockerapi.NetworkSettings{
+					Ports: map[dockerapi.Port][]dockerapi.PortBinding{
+						"80/tcp": tt.bindings,
+					},
+				},
+			}
+			got4, got6 := dd.publishedAddresses(container)
+			if len(got4) != len(tt.want4) || len(got6) != len(tt.want6) {
+				t.Fatalf("publishedAddresses() = %v, %v, want %v, %v", got4, got6, tt.want4, tt.want6)
+			}
+			for i := range got4 {
+				if !got4[i].Equal(tt.want4[i]) {
+					t.Errorf("publishedAddresses() ipv4 = %v, want %v", got4, tt.want4)
+				}
+			}
+			for i := range got6 {
+				if !got6[i].Equal(tt.want6[i]) {
+					t.Errorf("publishedAddresses() ipv6 = %v, want %v", got6, tt.want6)
+				}
+			}
+		})
+	}
+}
+
+func TestWildcardBindingNote(t *testing.T) {
+	for _, hostIP := range []string{"0.0.0.0", "::", ""} {
+		dd := NewDockerDiscovery("")
+		dd.opts.publishMode = publishModeHost
+		container := testContainer("0123456789abcdef", "web", "172.17.0.2")
+		container.NetworkSettings.Ports = map[dockerapi.Port][]dockerapi.PortBinding{
+			"80/tcp": {{HostIP: hostIP, HostPort: "80"}},
+		}
+		c, err := dd.parseContainer(container)
+		if err != nil {
+			t.Fatalf("parseContainer() error = %v", err)
+		}
+		if len(c.notes) != 1 {
+			t.Errorf("parseContainer() notes of binding %q = %v, want missing external addresses note", hostIP, c.notes)
+		}
+	}
+}
+
+func TestSplitIPs(t *testing.T) {
+	tests := []struct {
+		name    string
+		addrs   []string
+		want4   []string
+		want6   []string
+		wantErr bool
+	}{
+		{name: "mixed", addrs: []string{"192.168.1.10", "fd00::10", "10.0.0.1"}, want4: []string{"192.168.1.10", "10.0.0.1"}, want6: []string{"fd00::10"}},
+		{name: "zone is dropped", addrs: []string{"fe80::1%eth0"}, want4: []string{}, want6: []string{"fe80::1"}},
+		{name: "invalid", addrs: []string{"192.168.1.10", "host"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got4, got6, err := splitIPs(tt.addrs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitIPs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(ipStrings(got4), tt.want4) || !reflect.DeepEqual(ipStrings(got6), tt.want6) {
+				t.Errorf("splitIPs() = %v, %v, want %v, %v", got4, got6, tt.want4, tt.want6)
+			}
+		})
+	}
+}
+
+func TestHostAddresses(t *testing.T) {
+	for name, want := range map[string]bool{
+		"docker0":         true,
+		"docker_gwbridge": true,
+		"br-5e1b8a3f0c2d": true,
+		"veth1a2b3c4":     true,
+		"eth0":            false,
+		"bridge0":         false,
+	} {
+		if got := dockerInterface(name); got != want {
+			t.Errorf("dockerInterface(%s) = %v, want %v", name, got, want)
+		}
+	}
+
+	cidrs := []string{"127.0.0.1/8", "::1/128", "fe80::1/64", "169.254.0.5/16", "192.168.1.10/24", "fd00::10/64"}
+	var addrs []net.Addr
+	for _, cidr := range cidrs {
+		ip, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ipnet.IP = ip
+		addrs = append(addrs, ipnet)
+	}
+	got4, got6 := appendHostAddresses(nil, nil, addrs)
+	if want := []string{"192.168.1.10"}; !reflect.DeepEqual(ipStrings(got4), want) {
+		t.Errorf("appendHostAddresses() ipv4 = %v, want %v", got4, want)
+	}
+	if want := []string{"fd00::10"}; !reflect.DeepEqual(ipStrings(got6), want) {
+		t.Errorf("appendHostAddresses() ipv6 = %v, want %v", got6, want)
+	}
+}
@@ -2,8 +2,21 @@ package dockerdns
 
 import (
 	"net"
+	"sync"
+	"time"
 
 	csm "github.com/mhmtszr/concurrent-swiss-map"
+	"github.com/miekg/dns"
+)
+
+const (
+	conflictMerge     = "merge"
+	conflictFirstWins = "first_wins"
+	conflictLastWins  = "last_wins"
+	conflictReject    = "reject"
+
+	// journalSize bounds the number of changes kept for IXFR
+	journalSize = 100
 )
 
 type Map struct {
@@ -15,8 +28,55 @@ type Map struct {
 	// Key for the list of host names must be a literal IP address
 	// including IPv6 address without zone identifier.
 	// We don't support old-classful IP address notation.
-	addr        *csm.CsMap[string, []string]
+	addr *csm.CsMap[string, []string]
+
+	// owners of every host name and ip address in order of registration,
+	// name4, name6 and addr are built from them
+	owners     *csm.CsMap[string, []string] // [host, container_ids]
+	addrOwners *csm.CsMap[string, []string] // [ip, container_ids]
+	conflicts  map[string]struct{}          // hosts having several owners not sharing them on purpose
+
+	// effective ttl of every host name and ip address,
+	// the lowest ttl of their publishers
+	ttls     *csm.CsMap[string, uint32] // [host, ttl]
+	addrTTLs *csm.CsMap[string, uint32] // [ip, ttl]
+
+	// publishers of hosts having weight or priority labels
+	weighted *csm.CsMap[string, []*ContainerData] // [host, publishers]
+
+	// label records of containers
+	extra *csm.CsMap[string, []ownedRR] // [name, records]
+
+	// names of containers below every ancestor name, so empty non-terminals
+	// are found without scanning all names, reverse names of addresses are
+	// counted separately as they exist only with autoReverse
+	ents        *csm.CsMap[string, map[string]int] // [ancestor, container_id -> names below]
+	reverseENTs *csm.CsMap[string, map[string]int] // [ancestor, container_id -> addresses below]
+
 	autoReverse *bool
+	conflict    *string
+	ttl         *uint32 // default ttl of A and AAAA records
+	reverseTTL  *uint32 // default ttl of PTR records
+
+	// serial is incremented on every change of records,
+	// journal keeps the latest changes for incremental transfers
+	serial  uint32
+	journal []*journalEntry
+	pending *journalEntry
+
+	// listeners are called with every committed change while Map is locked,
+	// so they must not block
+	listeners []func(*journalEntry)
+
+	// mu serializes updates, lookups don't need it
+	mu sync.Mutex
+}
+
+// journalEntry is a set of record changes made by one Map update.
+type journalEntry struct {
+	serial  uint32
+	removed []dns.RR
+	added   []dns.RR
 }
 
 func newCSMap() *csm.CsMap[string, []net.IP] {
@@ -26,56 +86,597 @@ func newCSMap() *csm.CsMap[string, []net.IP] {
 	)
 }
 
+func newTTLMap() *csm.CsMap[string, uint32] {
+	return csm.Create[string, uint32](
+		csm.WithShardCount[string, uint32](32),
+		csm.WithSize[string, uint32](100),
+	)
+}
+
+func newMap(autoReverse *bool, conflict *string, ttl *uint32) *Map {
+	return &Map{
+		name4: newCSMap(),
+		name6: newCSMap(),
+		ids: csm.Create[string, *ContainerData](
+			csm.WithShardCount[string, *ContainerData](32),
+			csm.WithSize[string, *ContainerData](100),
+		),
+		addr:        newOwnersMap(),
+		owners:      newOwnersMap(),
+		conflicts:   map[string]struct{}{},
+		addrOwners:  newOwnersMap(),
+		ttls:        newTTLMap(),
+		addrTTLs:    newTTLMap(),
+		extra:       newRecordsMap(),
+		weighted:    newPublishersMap(),
+		ents:        newENTsMap(),
+		reverseENTs: newENTsMap(),
+		autoReverse: autoReverse,
+		conflict:    conflict,
+		ttl:         ttl,
+		reverseTTL:  ttl,
+		serial:      startSerial(uint32(time.Now().Unix())),
+	}
+}
+
+// lastSerial is the latest serial of all maps. Maps created on Corefile
+// reload continue after it, so secondaries holding it see their changes.
+var lastSerial struct {
+	sync.Mutex
+	serial uint32
+	set    bool
+}
+
+// startSerial returns serial of a new map, the later one of now and
+// the one following lastSerial.
+func startSerial(now uint32) uint32 {
+	lastSerial.Lock()
+	defer lastSerial.Unlock()
+	if lastSerial.set && !serialLess(lastSerial.serial, now) {
+		return nextSerial(lastSerial.serial)
+	}
+	return now
+}
+
+// storeSerial records serial of a map in lastSerial.
+func storeSerial(serial uint32) {
+	lastSerial.Lock()
+	defer lastSerial.Unlock()
+	if !lastSerial.set || serialLess(lastSerial.serial, serial) {
+		lastSerial.serial, lastSerial.set = serial, true
+	}
+}
+
+// advanceSerial moves serial past saved one, i.e. of a snapshot,
+// if it is behind it.
+func (m *Map) advanceSerial(saved uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !serialLess(saved, m.serial) {
+		m.serial = nextSerial(saved)
+		storeSerial(m.serial)
+	}
+}
+
+func newENTsMap() *csm.CsMap[string, map[string]int] {
+	return csm.Create[string, map[string]int](
+		csm.WithShardCount[string, map[string]int](32),
+		csm.WithSize[string, map[string]int](100),
+	)
+}
+
+// countAncestors adds delta to the number of names of container id below
+// every ancestor of name. Counts are copied on write, lookups don't lock Map.
+func countAncestors(ents *csm.CsMap[string, map[string]int], name, id string, delta int) {
+	for i, off := range dns.Split(name) {
+		if i == 0 {
+			continue // name itself
+		}
+		parent := name[off:]
+		old, _ := ents.Load(parent)
+		counts := make(map[string]int, len(old)+1)
+		for k, v := range old {
+			counts[k] = v
+		}
+		counts[id] += delta
+		if counts[id] <= 0 {
+			delete(counts, id)
+		}
+		if len(counts) == 0 {
+			ents.Delete(parent)
+		} else {
+			ents.Store(parent, counts)
+		}
+	}
+}
+
+func newOwnersMap() *csm.CsMap[string, []string] {
+	return csm.Create[string, []string](
+		csm.WithShardCount[string, []string](32),
+		csm.WithSize[string, []string](100),
+	)
+}
+
+// addContainer adds container records or replaces previously added ones.
 func (m *Map) addContainer(info *ContainerData) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.begin()
+	defer m.commit()
+	var known map[string]bool
+	if old, ok := m.ids.Load(info.id); ok {
+		known = m.conflicting(old)
+		m.unlink(old)
+	}
 	m.ids.Store(info.id, info)
-	for i := range info.hosts {
-		if len(info.ipv4) != 0 {
-			m.name4.Store(info.hosts[i], info.ipv4)
+	m.link(info, known)
+}
+
+func (m *Map) removeContainer(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	info, ok := m.ids.Load(id)
+	if !ok {
+		return
+	}
+	m.begin()
+	defer m.commit()
+	m.ids.Delete(info.id)
+	m.unlink(info)
+}
+
+func (m *Map) begin() {
+	m.pending = &journalEntry{}
+}
+
+// commit increments serial and stores pending changes in journal.
+func (m *Map) commit() {
+	e := m.pending
+	m.pending = nil
+	e.removed, e.added = diffRRs(e.removed, e.added)
+	if len(e.removed) == 0 && len(e.added) == 0 {
+		return
+	}
+	m.serial = nextSerial(m.serial)
+	e.serial = m.serial
+	storeSerial(m.serial)
+	m.journal = append(m.journal, e)
+	if len(m.journal) > journalSize {
+		m.journal = append(m.journal[:0:0], m.journal[len(m.journal)-journalSize:]...)
+	}
+	for _, f := range m.listeners {
+		f(e)
+	}
+}
+
+// subscribe registers listener of record changes.
+func (m *Map) subscribe(f func(*journalEntry)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, f)
+}
+
+// record saves change of records in pending journal entry.
+func (m *Map) record(removed, added []dns.RR) {
+	if m.pending == nil {
+		return
+	}
+	m.pending.removed = append(m.pending.removed, removed...)
+	m.pending.added = append(m.pending.added, added...)
+}
+
+// changes returns journal entries made after serial. It returns false
+// if journal doesn't have all of them.
+func (m *Map) changes(serial uint32) ([]*journalEntry, uint32, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.journal) == 0 || serialLess(nextSerial(serial), m.journal[0].serial) || serialLess(m.serial, serial) {
+		return nil, m.serial, false
+	}
+	for i, e := range m.journal {
+		if e.serial == nextSerial(serial) {
+			return m.journal[i:], m.serial, true
+		}
+	}
+	return nil, m.serial, serial == m.serial
+}
+
+// hasDescendant reports whether names below name are published by visible
+// containers, so name is an empty non-terminal if it has no records itself.
+func (m *Map) hasDescendant(name string, visible func(id string) bool) bool {
+	anyVisible := func(ents *csm.CsMap[string, map[string]int]) bool {
+		counts, _ := ents.Load(name)
+		for id := range counts {
+			if visible(id) {
+				return true
+			}
+		}
+		return false
+	}
+	return anyVisible(m.ents) || (*m.autoReverse && anyVisible(m.reverseENTs))
+}
+
+// nextSerial increments serial, serial 0 requests full transfer,
+// so it is skipped when serial wraps.
+func nextSerial(serial uint32) uint32 {
+	serial++
+	if serial == 0 {
+		serial++
+	}
+	return serial
+}
+
+func (m *Map) currentSerial() uint32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.serial
+}
+
+// nameTTL returns ttl of A and AAAA records of host.
+func (m *Map) nameTTL(host string) uint32 {
+	if ttl, ok := m.ttls.Load(host); ok {
+		return ttl
+	}
+	return *m.ttl
+}
+
+// addrTTL returns ttl of PTR records of ip address key.
+func (m *Map) addrTTL(key string) uint32 {
+	if ttl, ok := m.addrTTLs.Load(key); ok {
+		return ttl
+	}
+	return *m.reverseTTL
+}
+
+// minTTL returns the lowest ttl of containers, def is used for
+// containers without ttl label and when there are no containers.
+func minTTL(def uint32, containers []*ContainerData) uint32 {
+	if len(containers) == 0 {
+		return def
+	}
+	res := uint32(maxTTL)
+	for _, info := range containers {
+		ttl := def
+		if info.ttl != nil {
+			ttl = *info.ttl
 		}
-		if len(info.ipv6) != 0 {
-			m.name6.Store(info.hosts[i], info.ipv6)
+		if ttl < res {
+			res = ttl
 		}
 	}
-	if *m.autoReverse {
-		m.addAddrs(info)
+	return res
+}
+
+// records returns current serial and all records.
+func (m *Map) records() (uint32, []dns.RR) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rrs := []dns.RR{}
+	m.name4.Range(func(host string, ips []net.IP) bool {
+		rrs = append(rrs, a(host, m.nameTTL(host), ips)...)
+		return false
+	})
+	m.name6.Range(func(host string, ips []net.IP) bool {
+		rrs = append(rrs, aaaa(host, m.nameTTL(host), ips)...)
+		return false
+	})
+	m.addr.Range(func(key string, names []string) bool {
+		if rev, err := dns.ReverseAddr(key); err == nil {
+			rrs = append(rrs, ptr(rev, m.addrTTL(key), names)...)
+		}
+		return false
+	})
+	m.extra.Range(func(_ string, owned []ownedRR) bool {
+		for i, o := range owned {
+			if !hasRR(owned[:i], o.rr) {
+				rrs = append(rrs, dns.Copy(o.rr))
+			}
+		}
+		return false
+	})
+	return m.serial, rrs
+}
+
+func (m *Map) policy() string {
+	if m.conflict == nil || *m.conflict == "" {
+		return conflictMerge
 	}
+	return *m.conflict
 }
 
-func (m *Map) addAddrs(info *ContainerData) {
-	add := func(ips []net.IP, hosts []string) {
-		for i := range ips {
-			names, ok := m.addr.Load(string(ips[i]))
-			if ok {
-				names = append(names, hosts...)
-			} else {
-				names = hosts
+// link registers container as owner of its hosts and addresses. Conflicts
+// of hosts in known ones were reported before container was replaced.
+func (m *Map) link(info *ContainerData, known map[string]bool) {
+	for _, host := range info.hosts {
+		owners, _ := m.owners.Load(host)
+		if indexOf(owners, info.id) >= 0 {
+			continue
+		}
+		if m.conflictsWith(info, host, owners) {
+			policy := m.policy()
+			if !known[host] {
+				log.Warningf("[docker] name %s of container %s conflicts with containers %v, policy %s",
+					host, shortID(info.id), shortIDs(owners), policy)
+				conflictsTotal.WithLabelValues(policy).Inc()
+			}
+			if policy == conflictReject {
+				continue
 			}
-			m.addr.Store(string(ips[i]), names)
 		}
+		owners = append(owners[:len(owners):len(owners)], info.id)
+		m.owners.Store(host, owners)
+		countAncestors(m.ents, host, info.id, 1)
+		m.refreshConflict(host, owners)
+		m.refreshName(host)
+	}
+	for _, ip := range info.ips() {
+		key := ip.String()
+		owners, _ := m.addrOwners.Load(key)
+		if indexOf(owners, info.id) >= 0 {
+			continue
+		}
+		m.addrOwners.Store(key, append(owners[:len(owners):len(owners)], info.id))
+		if rev, err := dns.ReverseAddr(key); err == nil {
+			countAncestors(m.reverseENTs, rev, info.id, 1)
+		}
+		m.refreshAddr(key)
 	}
-	add(info.ipv4, info.hosts)
-	add(info.ipv6, info.hosts)
+	m.linkRecords(info)
 }
 
-func (m *Map) removeContainer(id string) {
-	info, ok := m.ids.Load(id)
-	if !ok {
+// unlink removes container from owners of its hosts and addresses.
+func (m *Map) unlink(info *ContainerData) {
+	for _, host := range info.hosts {
+		owners, _ := m.owners.Load(host)
+		i := indexOf(owners, info.id)
+		if i < 0 {
+			continue
+		}
+		owners = removeAt(owners, i)
+		if len(owners) == 0 {
+			m.owners.Delete(host)
+		} else {
+			m.owners.Store(host, owners)
+		}
+		countAncestors(m.ents, host, info.id, -1)
+		m.refreshConflict(host, owners)
+		m.refreshName(host)
+	}
+	for _, ip := range info.ips() {
+		key := ip.String()
+		owners, _ := m.addrOwners.Load(key)
+		i := indexOf(owners, info.id)
+		if i < 0 {
+			continue
+		}
+		owners = removeAt(owners, i)
+		if len(owners) == 0 {
+			m.addrOwners.Delete(key)
+		} else {
+			m.addrOwners.Store(key, owners)
+		}
+		if rev, err := dns.ReverseAddr(key); err == nil {
+			countAncestors(m.reverseENTs, rev, info.id, -1)
+		}
+		m.refreshAddr(key)
+	}
+	m.unlinkRecords(info)
+}
+
+// conflictsWith reports whether host of container conflicts with other owners.
+func (m *Map) conflictsWith(info *ContainerData, host string, owners []string) bool {
+	return len(owners) != 0 && !(info.sharedHost(host) && m.sharedHost(host, owners))
+}
+
+// conflicting returns hosts of linked container conflicting with other
+// owners, including hosts rejected by reject policy.
+func (m *Map) conflicting(info *ContainerData) map[string]bool {
+	res := map[string]bool{}
+	for _, host := range info.hosts {
+		owners, _ := m.owners.Load(host)
+		if i := indexOf(owners, info.id); i >= 0 {
+			owners = removeAt(owners, i)
+		}
+		if m.conflictsWith(info, host, owners) {
+			res[host] = true
+		}
+	}
+	return res
+}
+
+// sharedHost reports whether all owners publish host as a name shared on purpose.
+func (m *Map) sharedHost(host string, owners []string) bool {
+	for _, id := range owners {
+		info, ok := m.ids.Load(id)
+		if !ok || !info.sharedHost(host) {
+			return false
+		}
+	}
+	return true
+}
+
+// refreshConflict updates conflict state of host with owners.
+func (m *Map) refreshConflict(host string, owners []string) {
+	if len(owners) > 1 && !m.sharedHost(host, owners) {
+		m.conflicts[host] = struct{}{}
+	} else {
+		delete(m.conflicts, host)
+	}
+	conflictNames.Set(float64(len(m.conflicts)))
+}
+
+// publishers returns containers which addresses are served for host
+// according to conflict policy, all owners publish shared hosts.
+func (m *Map) publishers(host string) []*ContainerData {
+	owners, _ := m.owners.Load(host)
+	if len(owners) == 0 {
+		return nil
+	}
+	policy := m.policy()
+	if m.sharedHost(host, owners) {
+		policy = conflictMerge
+	}
+	switch policy {
+	case conflictFirstWins, conflictReject:
+		owners = owners[:1]
+	case conflictLastWins:
+		owners = owners[len(owners)-1:]
+	}
+	res := make([]*ContainerData, 0, len(owners))
+	for _, id := range owners {
+		if info, ok := m.ids.Load(id); ok {
+			res = append(res, info)
+		}
+	}
+	return res
+}
+
+func (m *Map) refreshName(host string) {
+	var ipv4, ipv6 []net.IP
+	publishers := m.publishers(host)
+	for _, info := range publishers {
+		ipv4 = appendIPs(ipv4, info.ipv4)
+		ipv6 = appendIPs(ipv6, info.ipv6)
+	}
+	weighted := false
+	for _, info := range publishers {
+		weighted = weighted || info.weighted()
+	}
+	if weighted {
+		m.weighted.Store(host, publishers)
+	} else {
+		m.weighted.Delete(host)
+	}
+	ttl := minTTL(*m.ttl, publishers)
+	oldTTL := m.nameTTL(host)
+	old4, _ := m.name4.Load(host)
+	old6, _ := m.name6.Load(host)
+	m.record(append(a(host, oldTTL, old4), aaaa(host, oldTTL, old6)...), append(a(host, ttl, ipv4), aaaa(host, ttl, ipv6)...))
+	if len(ipv4) != 0 || len(ipv6) != 0 {
+		m.ttls.Store(host, ttl)
+	} else {
+		m.ttls.Delete(host)
+	}
+	if len(ipv4) != 0 {
+		m.name4.Store(host, ipv4)
+	} else {
+		m.name4.Delete(host)
+	}
+	if len(ipv6) != 0 {
+		m.name6.Store(host, ipv6)
+	} else {
+		m.name6.Delete(host)
+	}
+}
+
+func (m *Map) refreshAddr(key string) {
+	if !*m.autoReverse {
 		return
 	}
-	m.ids.Delete(info.id)
-	for i := range info.hosts {
-		m.name4.Delete(info.hosts[i])
-		m.name6.Delete(info.hosts[i])
+	owners, _ := m.addrOwners.Load(key)
+	names := []string{}
+	set := map[string]struct{}{}
+	containers := []*ContainerData{}
+	for _, id := range owners {
+		info, ok := m.ids.Load(id)
+		if !ok {
+			continue
+		}
+		containers = append(containers, info)
+		for _, host := range info.hosts {
+			if _, ok := set[host]; ok {
+				continue
+			}
+			// skip hosts rejected by conflict policy
+			hostOwners, _ := m.owners.Load(host)
+			if indexOf(hostOwners, id) < 0 {
+				continue
+			}
+			set[host] = struct{}{}
+			names = append(names, host)
+		}
+	}
+	ttl := minTTL(*m.reverseTTL, containers)
+	if rev, err := dns.ReverseAddr(key); err == nil {
+		old, _ := m.addr.Load(key)
+		m.record(ptr(rev, m.addrTTL(key), old), ptr(rev, ttl, names))
+	}
+	if len(names) != 0 {
+		m.addr.Store(key, names)
+		m.addrTTLs.Store(key, ttl)
+	} else {
+		m.addr.Delete(key)
+		m.addrTTLs.Delete(key)
 	}
-	m.rmAddrs(info)
 }
 
-func (m *Map) rmAddrs(info *ContainerData) {
-	rm := func(ips []net.IP) {
-		for i := range ips {
-			m.addr.Delete(string(ips[i]))
+func (c *ContainerData) ips() []net.IP {
+	res := make([]net.IP, 0, len(c.ipv4)+len(c.ipv6))
+	res = append(res, c.ipv4...)
+	return append(res, c.ipv6...)
+}
+
+// appendIPs appends ips missing in dst.
+func appendIPs(dst, ips []net.IP) []net.IP {
+	for _, ip := range ips {
+		if !containsIP(dst, ip) {
+			dst = append(dst, ip)
 		}
 	}
-	rm(info.ipv4)
-	rm(info.ipv6)
+	return dst
+}
+
+// diffRRs drops records present in both lists.
+func diffRRs(removed, added []dns.RR) ([]dns.RR, []dns.RR) {
+	count := map[string]int{}
+	for _, rr := range removed {
+		count[rr.String()]--
+	}
+	for _, rr := range added {
+		count[rr.String()]++
+	}
+	filter := func(rrs []dns.RR, sign int) []dns.RR {
+		res := []dns.RR{}
+		for _, rr := range rrs {
+			key := rr.String()
+			if count[key]*sign > 0 {
+				res = append(res, rr)
+				count[key] -= sign
+			}
+		}
+		return res
+	}
+	return filter(removed, -1), filter(added, 1)
+}
+
+func indexOf(list []string, s string) int {
+	for i := range list {
+		if list[i] == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeAt returns copy of list without i-th element,
+// stored slices are never modified in place.
+func removeAt(list []string, i int) []string {
+	res := make([]string, 0, len(list)-1)
+	res = append(res, list[:i]...)
+	return append(res, list[i+1:]...)
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+func shortIDs(ids []string) []string {
+	res := make([]string, len(ids))
+	for i := range ids {
+		res[i] = shortID(ids[i])
+	}
+	return res
 }
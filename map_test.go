@@ -0,0 +1,213 @@
+package dockerdns
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newTestMap(policy string) *Map {
+	autoReverse := true
+	ttl := uint32(30)
+	return newMap(&autoReverse, &policy, &ttl)
+}
+
+func TestMapConflict(t *testing.T) {
+	first := &ContainerData{id: "first", hosts: []string{"web.loc."}, ipv4: []net.IP{parseIP("172.28.0.2")}}
+	second := &ContainerData{id: "second", hosts: []string{"web.loc."}, ipv4: []net.IP{parseIP("172.28.0.3")}}
+	tests := []struct {
+		policy      string
+		want        []net.IP
+		afterRemove []net.IP // after first container is removed
+	}{
+		{
+			policy:      conflictMerge,
+			want:        []net.IP{parseIP("172.28.0.2"), parseIP("172.28.0.3")},
+			afterRemove: []net.IP{parseIP("172.28.0.3")},
+		},
+		{
+			policy:      conflictFirstWins,
+			want:        []net.IP{parseIP("172.28.0.2")},
+			afterRemove: []net.IP{parseIP("172.28.0.3")},
+		},
+		{
+			policy:      conflictLastWins,
+			want:        []net.IP{parseIP("172.28.0.3")},
+			afterRemove: []net.IP{parseIP("172.28.0.3")},
+		},
+		{
+			policy:      conflictReject,
+			want:        []net.IP{parseIP("172.28.0.2")},
+			afterRemove: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			m := newTestMap(tt.policy)
+			m.addContainer(first)
+			m.addContainer(second)
+			got, _ := m.name4.Load("web.loc.")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("name4 = %v, want %v", got, tt.want)
+			}
+			m.removeContainer(first.id)
+			got, _ = m.name4.Load("web.loc.")
+			if !reflect.DeepEqual(got, tt.afterRemove) {
+				t.Errorf("name4 after remove = %v, want %v", got, tt.afterRemove)
+			}
+			names, _ := m.addr.Load("172.28.0.3")
+			if tt.afterRemove != nil && !reflect.DeepEqual(names, []string{"web.loc."}) {
+				t.Errorf("addr = %v, want %v", names, []string{"web.loc."})
+			}
+			if tt.afterRemove == nil && names != nil {
+				t.Errorf("addr = %v, want rejected name to be absent", names)
+			}
+		})
+	}
+}
+
+func TestMapReplaceContainer(t *testing.T) {
+	m := newTestMap(conflictMerge)
+	m.addContainer(&ContainerData{id: "id", hosts: []string{"old.loc."}, ipv4: []net.IP{parseIP("172.28.0.2")}})
+	m.addContainer(&ContainerData{id: "id", hosts: []string{"new.loc."}, ipv4: []net.IP{parseIP("172.28.0.3")}})
+	if m.name4.Has("old.loc.") {
+		t.Errorf("stale name old.loc. is still present")
+	}
+	if m.addr.Has("172.28.0.2") {
+		t.Errorf("stale address 172.28.0.2 is still present")
+	}
+	if names, _ := m.addr.Load("172.28.0.3"); !reflect.DeepEqual(names, []string{"new.loc."}) {
+		t.Errorf("addr = %v, want %v", names, []string{"new.loc."})
+	}
+}
+
+func TestMapTTL(t *testing.T) {
+	ttl := func(v uint32) *uint32 { return &v }
+	m := newTestMap(conflictMerge)
+	reverseTTL := uint32(60)
+	m.reverseTTL = &reverseTTL
+	m.addContainer(&ContainerData{id: "default", hosts: []string{"web.loc."}, ipv4: []net.IP{parseIP("172.28.0.2")}})
+	if got := m.nameTTL("web.loc."); got != 30 {
+		t.Errorf("nameTTL() = %d, want default 30", got)
+	}
+	if got := m.addrTTL("172.28.0.2"); got != 60 {
+		t.Errorf("addrTTL() = %d, want reverse 60", got)
+	}
+	m.addContainer(&ContainerData{id: "short", hosts: []string{"web.loc."}, ipv4: []net.IP{parseIP("172.28.0.3")}, ttl: ttl(5)})
+	m.addContainer(&ContainerData{id: "long", hosts: []string{"web.loc."}, ipv4: []net.IP{parseIP("172.28.0.4")}, ttl: ttl(7200)})
+	if got := m.nameTTL("web.loc."); got != 5 {
+		t.Errorf("nameTTL() = %d, want the lowest label 5", got)
+	}
+	if got := m.addrTTL("172.28.0.4"); got != 7200 {
+		t.Errorf("addrTTL() = %d, want label 7200", got)
+	}
+	m.removeContainer("short")
+	_, records := m.records()
+	for _, rr := range records {
+		if rr.Header().Name == "web.loc." && rr.Header().Ttl != 30 {
+			t.Errorf("record %s, want ttl 30 of unlabeled container", rr)
+		}
+	}
+}
+
+func TestMapSharedNames(t *testing.T) {
+	dd := NewDockerDiscovery("")
+	dd.Origins = []string{"loc."}
+	dd.addRZones()
+	dd.opts.byHostname = true
+	dd.opts.byComposeDomain = true
+	dd.opts.byComposeProject = true
+	dd.opts.conflict = conflictFirstWins
+
+	// service web is scaled to three replicas
+	for i := 1; i <= 3; i++ {
+		c := &ContainerData{id: fmt.Sprintf("web%d", i), hostname: "web", project: "proj", service: "web",
+			ipv4: []net.IP{net.IPv4(172, 28, 0, byte(i+1))}}
+		dd.resolveHosts(c)
+		dd.hmap.addContainer(c)
+	}
+	if ips, _ := dd.hmap.name4.Load("web.proj.loc."); len(ips) != 3 {
+		t.Errorf("service name addresses = %v, want all replicas", ips)
+	}
+	if ips, _ := dd.hmap.name4.Load("proj.loc."); len(ips) != 3 {
+		t.Errorf("project name addresses = %v, want all replicas", ips)
+	}
+	// hostname is not shared, so policy applies
+	if ips, _ := dd.hmap.name4.Load("web.loc."); len(ips) != 1 {
+		t.Errorf("hostname addresses = %v, want the first replica", ips)
+	}
+	if want := map[string]struct{}{"web.loc.": {}}; !reflect.DeepEqual(dd.hmap.conflicts, want) {
+		t.Errorf("conflicts = %v, want %v", dd.hmap.conflicts, want)
+	}
+	dd.hmap.removeContainer("web1")
+	dd.hmap.removeContainer("web2")
+	if len(dd.hmap.conflicts) != 0 {
+		t.Errorf("conflicts = %v, want none", dd.hmap.conflicts)
+	}
+}
+
+func TestMapConflictCountedOnce(t *testing.T) {
+	for _, policy := range []string{conflictMerge, conflictReject} {
+		t.Run(policy, func(t *testing.T) {
+			m := newTestMap(policy)
+			counter := conflictsTotal.WithLabelValues(policy)
+			start := testutil.ToFloat64(counter)
+			m.addContainer(&ContainerData{id: "first", hosts: []string{"web.loc."}, ipv4: []net.IP{parseIP("172.28.0.2")}})
+			second := &ContainerData{id: "second", hosts: []string{"web.loc."}, ipv4: []net.IP{parseIP("172.28.0.3")}}
+			m.addContainer(second)
+			// rescans and network events replace containers in the same conflict
+			m.addContainer(second)
+			m.addContainer(&ContainerData{id: "second", hosts: []string{"web.loc."}, ipv4: []net.IP{parseIP("172.28.0.4")}})
+			if got := testutil.ToFloat64(counter) - start; got != 1 {
+				t.Errorf("conflicts counted %v times, want 1", got)
+			}
+			// conflict arising again is counted, rejected container doesn't own the name
+			m.removeContainer("first")
+			m.addContainer(&ContainerData{id: "first", hosts: []string{"web.loc."}, ipv4: []net.IP{parseIP("172.28.0.2")}})
+			want := 2.0
+			if policy == conflictReject {
+				want = 1
+			}
+			if got := testutil.ToFloat64(counter) - start; got != want {
+				t.Errorf("conflicts counted %v times, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestMapEmptyNonTerminals(t *testing.T) {
+	m := newTestMap(conflictMerge)
+	all := func(string) bool { return true }
+	web := &ContainerData{id: "web", hosts: []string{"web.app.loc."}, ipv4: []net.IP{parseIP("172.28.0.2")},
+		records: []dns.RR{
+			test.TXT(`a.txt.loc. 300 IN TXT "one"`),
+			test.TXT(`a.txt.loc. 300 IN TXT "two"`),
+		}}
+	m.addContainer(web)
+	// replacing container keeps counts consistent
+	m.addContainer(web)
+
+	for _, name := range []string{"app.loc.", "loc.", "txt.loc.", "28.172.in-addr.arpa."} {
+		if !m.hasDescendant(name, all) {
+			t.Errorf("hasDescendant(%s) = false, want true", name)
+		}
+	}
+	for _, name := range []string{"web.app.loc.", "other.loc.", "a.txt.loc."} {
+		if m.hasDescendant(name, all) {
+			t.Errorf("hasDescendant(%s) = true, want false", name)
+		}
+	}
+	if m.hasDescendant("app.loc.", func(id string) bool { return id != "web" }) {
+		t.Errorf("hasDescendant() of invisible container = true, want false")
+	}
+
+	m.removeContainer("web")
+	if m.ents.Count() != 0 || m.reverseENTs.Count() != 0 {
+		t.Errorf("empty non-terminals are left after container is removed")
+	}
+}
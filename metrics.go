@@ -0,0 +1,25 @@
+package dockerdns
+
+import (
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// conflictsTotal counts detected name conflicts between containers.
+	conflictsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "docker",
+		Name:      "name_conflicts_total",
+		Help:      "Counter of names claimed by more than one container.",
+	}, []string{"policy"})
+	// conflictNames is the number of names currently owned by several containers.
+	conflictNames = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "docker",
+		Name:      "conflicting_names",
+		Help:      "The number of names currently owned by more than one container.",
+	})
+)
@@ -0,0 +1,149 @@
+package dockerdns
+
+import (
+	"encoding/base32"
+	"sort"
+
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
+	"github.com/miekg/dns"
+)
+
+// NSEC3 parameters recommended by RFC 9276: no additional iterations and no salt.
+const (
+	nsec3Iterations = 0
+	nsec3Salt       = ""
+)
+
+// nsec3Denial returns NSEC3 "white lies" (RFC 7129 appendix B) denying qname
+// or its records of requested type. Records are synthesized per answer and
+// cover only the denied hash, so the zone can't be walked. Existing name is
+// denied with NSEC3 matching it, missing name with closest encloser proof:
+// NSEC3 matching the closest encloser and NSEC3 covering the next closer
+// name and the wildcard of the closest encloser.
+func (dd *DockerDiscovery) nsec3Denial(v *view, qname, zone string, exists bool, ttl uint32) []dns.RR {
+	if exists {
+		return []dns.RR{dd.nsec3Match(v, qname, zone, ttl)}
+	}
+	ce, next := dd.closestEncloser(v, qname, zone)
+	rrs := []dns.RR{
+		dd.nsec3Match(v, ce, zone, ttl),
+		nsec3Cover(next, zone, ttl),
+	}
+	// hashes of next closer name and wildcard rarely share a record
+	if wildcard := nsec3Cover("*."+ce, zone, ttl); !dns.IsDuplicate(wildcard, rrs[1]) {
+		rrs = append(rrs, wildcard)
+	}
+	return rrs
+}
+
+// closestEncloser returns the longest existing ancestor of missing qname
+// and its child on the way to qname, the next closer name.
+func (dd *DockerDiscovery) closestEncloser(v *view, qname, zone string) (string, string) {
+	name := qname
+	for {
+		i, _ := dns.NextLabel(name, 0)
+		parent := name[i:]
+		if !dns.IsSubDomain(zone, parent) {
+			return zone, name
+		}
+		if parent == zone || dd.nameExists(v, parent, zone) {
+			return parent, name
+		}
+		name = parent
+	}
+}
+
+// nsec3Match returns NSEC3 record matching existing name with its types.
+func (dd *DockerDiscovery) nsec3Match(v *view, name, zone string, ttl uint32) dns.RR {
+	hash := dns.HashName(name, dns.SHA1, nsec3Iterations, nsec3Salt)
+	rr := newNSEC3(hash, zone, ttl)
+	rr.NextDomain = nextHash(hash, 1)
+	rr.TypeBitMap = dd.nameTypes(v, name, zone)
+	return rr
+}
+
+// nsec3Cover returns NSEC3 record covering hash of missing name.
+func nsec3Cover(name, zone string, ttl uint32) dns.RR {
+	hash := dns.HashName(name, dns.SHA1, nsec3Iterations, nsec3Salt)
+	rr := newNSEC3(nextHash(hash, -1), zone, ttl)
+	rr.NextDomain = nextHash(hash, 1)
+	return rr
+}
+
+func newNSEC3(owner, zone string, ttl uint32) *dns.NSEC3 {
+	return &dns.NSEC3{
+		Hdr:        dns.RR_Header{Name: dnsutil.Join(owner, zone), Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: ttl},
+		Hash:       dns.SHA1,
+		Iterations: nsec3Iterations,
+		SaltLength: uint8(len(nsec3Salt) / 2),
+		Salt:       nsec3Salt,
+		HashLength: 20,
+	}
+}
+
+// nsec3Param returns NSEC3PARAM record of zone.
+func nsec3Param(zone string, ttl uint32) dns.RR {
+	return &dns.NSEC3PARAM{
+		Hdr:        dns.RR_Header{Name: zone, Rrtype: dns.TypeNSEC3PARAM, Class: dns.ClassINET, Ttl: ttl},
+		Hash:       dns.SHA1,
+		Iterations: nsec3Iterations,
+		SaltLength: uint8(len(nsec3Salt) / 2),
+		Salt:       nsec3Salt,
+	}
+}
+
+// nextHash returns base32hex encoded hash incremented by delta, 1 or -1.
+func nextHash(hash string, delta int) string {
+	b, err := base32.HexEncoding.WithPadding(base32.NoPadding).DecodeString(hash)
+	if err != nil {
+		return hash
+	}
+	for i := len(b) - 1; i >= 0; i-- {
+		old := b[i]
+		b[i] += byte(delta)
+		// stop unless the byte wrapped
+		if (delta > 0 && b[i] > old) || (delta < 0 && b[i] < old) {
+			break
+		}
+	}
+	return base32.HexEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+}
+
+// nameTypes returns types of records of name answered in view,
+// empty non-terminals have no types.
+func (dd *DockerDiscovery) nameTypes(v *view, name, zone string) []uint16 {
+	set := map[uint16]struct{}{}
+	if name == zone {
+		for _, t := range []uint16{dns.TypeSOA, dns.TypeNS, dns.TypeDNSKEY, dns.TypeNSEC3PARAM} {
+			set[t] = struct{}{}
+		}
+	}
+	if dnsutil.IsReverse(name) > 0 {
+		if len(dd.ptrNames(v, dnsutil.ExtractAddressFromReverse(name))) != 0 {
+			set[dns.TypePTR] = struct{}{}
+		}
+	} else {
+		if len(dd.typeRecords(v, name, zone, dns.TypeA, nil)) != 0 {
+			set[dns.TypeA] = struct{}{}
+		}
+		if len(dd.typeRecords(v, name, zone, dns.TypeAAAA, nil)) != 0 {
+			set[dns.TypeAAAA] = struct{}{}
+		}
+		if len(dd.svcbRecords(v, name, dns.TypeHTTPS)) != 0 {
+			set[dns.TypeHTTPS] = struct{}{}
+			set[dns.TypeSVCB] = struct{}{}
+		}
+	}
+	for _, rr := range dd.hmap.allExtraRecords(name, dd.visibleID(v)) {
+		set[rr.Header().Rrtype] = struct{}{}
+	}
+	if len(set) != 0 {
+		set[dns.TypeRRSIG] = struct{}{}
+	}
+	types := make([]uint16, 0, len(set))
+	for t := range set {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
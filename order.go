@@ -0,0 +1,153 @@
+package dockerdns
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	orderStatic     = "static"
+	orderShuffle    = "shuffle"
+	orderRoundRobin = "round_robin"
+	orderPreferIPv4 = "prefer_ipv4_network"
+)
+
+// answerOrder reorders A and AAAA records of answers:
+//
+//	static      keeps order of registration
+//	shuffle     random order on every answer
+//	round_robin rotates records by per-name counter
+//
+// addresses of preferred network are moved to the front afterwards.
+type answerOrder struct {
+	mode   string
+	prefer *net.IPNet
+
+	// shuffle is rand.Shuffle, replaced in tests
+	shuffle func(n int, swap func(i, j int))
+
+	mu       sync.Mutex
+	counters map[string]uint32 // [name/type, rotation], names without addresses are forgotten
+}
+
+// newAnswerOrder parses order directive arguments:
+// static|shuffle|round_robin [prefer_ipv4_network CIDR] or prefer_ipv4_network CIDR
+func newAnswerOrder(args []string) (*answerOrder, error) {
+	o := &answerOrder{
+		mode:     orderStatic,
+		shuffle:  rand.Shuffle,
+		counters: map[string]uint32{},
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("order mode is required")
+	}
+	switch args[0] {
+	case orderStatic, orderShuffle, orderRoundRobin:
+		o.mode = args[0]
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return o, nil
+	}
+	if args[0] != orderPreferIPv4 || len(args) != 2 {
+		return nil, fmt.Errorf("order must be %s, %s or %s optionally followed by %s CIDR: %s",
+			orderStatic, orderShuffle, orderRoundRobin, orderPreferIPv4, strings.Join(args, " "))
+	}
+	_, ipnet, err := net.ParseCIDR(args[1])
+	if err != nil || ipnet.IP.To4() == nil {
+		return nil, fmt.Errorf("invalid ipv4 network: %s", args[1])
+	}
+	o.prefer = ipnet
+	return o, nil
+}
+
+func (o *answerOrder) String() string {
+	if o.prefer == nil {
+		return o.mode
+	}
+	return o.mode + " " + orderPreferIPv4 + " " + o.prefer.String()
+}
+
+// apply reorders every A and AAAA record set of answers in place.
+func (o *answerOrder) apply(answers []dns.RR) {
+	sets := map[string][]int{}
+	keys := []string{}
+	for i, rr := range answers {
+		t := rr.Header().Rrtype
+		if t != dns.TypeA && t != dns.TypeAAAA {
+			continue
+		}
+		key := rr.Header().Name + "/" + dns.TypeToString[t]
+		if _, ok := sets[key]; !ok {
+			keys = append(keys, key)
+		}
+		sets[key] = append(sets[key], i)
+	}
+	for _, key := range keys {
+		idx := sets[key]
+		if len(idx) < 2 {
+			continue
+		}
+		set := make([]dns.RR, len(idx))
+		for j, i := range idx {
+			set[j] = answers[i]
+		}
+		o.reorder(key, set)
+		for j, i := range idx {
+			answers[i] = set[j]
+		}
+	}
+}
+
+func (o *answerOrder) reorder(key string, set []dns.RR) {
+	switch o.mode {
+	case orderShuffle:
+		o.shuffle(len(set), func(i, j int) { set[i], set[j] = set[j], set[i] })
+	case orderRoundRobin:
+		o.mu.Lock()
+		n := o.counters[key]
+		o.counters[key] = n + 1
+		o.mu.Unlock()
+		shift := int(n % uint32(len(set)))
+		rotated := append(append([]dns.RR{}, set[shift:]...), set[:shift]...)
+		copy(set, rotated)
+	}
+	if o.prefer != nil {
+		sort.SliceStable(set, func(i, j int) bool {
+			return o.preferred(set[i]) && !o.preferred(set[j])
+		})
+	}
+}
+
+// forget returns Map listener dropping counters of names which have
+// no addresses of the type anymore, so counters don't outlive containers.
+func (o *answerOrder) forget(m *Map) func(*journalEntry) {
+	return func(e *journalEntry) {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		for _, rr := range e.removed {
+			name := rr.Header().Name
+			switch rr.Header().Rrtype {
+			case dns.TypeA:
+				if !m.name4.Has(name) {
+					delete(o.counters, name+"/A")
+				}
+			case dns.TypeAAAA:
+				if !m.name6.Has(name) {
+					delete(o.counters, name+"/AAAA")
+				}
+			}
+		}
+	}
+}
+
+func (o *answerOrder) preferred(rr dns.RR) bool {
+	r, ok := rr.(*dns.A)
+	return ok && o.prefer.Contains(r.A)
+}
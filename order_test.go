@@ -0,0 +1,105 @@
+package dockerdns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestAnswerOrder(t *testing.T) {
+	ips := []net.IP{parseIP("172.28.0.2"), parseIP("10.0.0.3"), parseIP("172.28.0.4")}
+	reverse := func(n int, swap func(i, j int)) {
+		for i := 0; i < n/2; i++ {
+			swap(i, n-1-i)
+		}
+	}
+	addrs := func(rrs []dns.RR) []string {
+		res := []string{}
+		for _, rr := range rrs {
+			res = append(res, rr.(*dns.A).A.String())
+		}
+		return res
+	}
+	tests := []struct {
+		name string
+		args []string
+		want [][]string // addresses of consecutive answers
+	}{
+		{
+			name: "static",
+			args: []string{"static"},
+			want: [][]string{{"172.28.0.2", "10.0.0.3", "172.28.0.4"}, {"172.28.0.2", "10.0.0.3", "172.28.0.4"}},
+		},
+		{
+			name: "shuffle",
+			args: []string{"shuffle"},
+			want: [][]string{{"172.28.0.4", "10.0.0.3", "172.28.0.2"}},
+		},
+		{
+			name: "round robin",
+			args: []string{"round_robin"},
+			want: [][]string{
+				{"172.28.0.2", "10.0.0.3", "172.28.0.4"},
+				{"10.0.0.3", "172.28.0.4", "172.28.0.2"},
+				{"172.28.0.4", "172.28.0.2", "10.0.0.3"},
+				{"172.28.0.2", "10.0.0.3", "172.28.0.4"},
+			},
+		},
+		{
+			name: "prefer network",
+			args: []string{"prefer_ipv4_network", "10.0.0.0/8"},
+			want: [][]string{{"10.0.0.3", "172.28.0.2", "172.28.0.4"}},
+		},
+		{
+			name: "round robin with preferred network",
+			args: []string{"round_robin", "prefer_ipv4_network", "172.28.0.0/16"},
+			want: [][]string{{"172.28.0.2", "172.28.0.4", "10.0.0.3"}, {"172.28.0.4", "172.28.0.2", "10.0.0.3"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := newAnswerOrder(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			o.shuffle = reverse
+			for i, want := range tt.want {
+				answers := a("web.loc.", 30, ips)
+				o.apply(answers)
+				if got := addrs(answers); !reflect.DeepEqual(got, want) {
+					t.Errorf("answer %d = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+
+	for _, args := range [][]string{{}, {"random"}, {"static", "prefer_ipv4_network"}, {"prefer_ipv4_network", "fd00::/8"}} {
+		if _, err := newAnswerOrder(args); err == nil {
+			t.Errorf("newAnswerOrder(%v) want error", args)
+		}
+	}
+}
+
+func TestAnswerOrderForget(t *testing.T) {
+	o, err := newAnswerOrder([]string{"round_robin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newTestMap(conflictMerge)
+	m.subscribe(o.forget(m))
+	ips := []net.IP{parseIP("172.28.0.2"), parseIP("172.28.0.3")}
+	m.addContainer(&ContainerData{id: "web1", hosts: []string{"web.loc."}, ipv4: ips[:1]})
+	m.addContainer(&ContainerData{id: "web2", hosts: []string{"web.loc."}, ipv4: ips[1:]})
+	o.apply(a("web.loc.", 30, ips))
+
+	m.removeContainer("web1")
+	if len(o.counters) != 1 {
+		t.Errorf("counters = %v, want counter of name still having addresses", o.counters)
+	}
+	m.removeContainer("web2")
+	if len(o.counters) != 0 {
+		t.Errorf("counters = %v, want none after the name is removed", o.counters)
+	}
+}
@@ -8,11 +8,13 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	clog "github.com/coredns/coredns/plugin/pkg/log"
 
 	"github.com/coredns/caddy"
 
+	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/pkg/dnsutil"
 	dockerapi "github.com/fsouza/go-dockerclient"
@@ -84,6 +86,22 @@ func ParseStanza(c *caddy.Controller) (*DockerDiscovery, error) {
 				return dd, c.ArgErr()
 			}
 			dd.opts.byComposeDomain = true
+		case "by_compose_project":
+			if c.NextArg() {
+				return dd, c.ArgErr()
+			}
+			dd.opts.byComposeProject = true
+		case "enable_projects":
+			args := c.RemainingArgs()
+			if len(args) == 0 {
+				return nil, c.ArgErr()
+			}
+			dd.opts.enabledProjects = append(dd.opts.enabledProjects, args...)
+		case "by_compose_index":
+			if c.NextArg() {
+				return dd, c.ArgErr()
+			}
+			dd.opts.byComposeIndex = true
 		case "enabled_by_default":
 			if c.NextArg() {
 				return dd, c.ArgErr()
@@ -99,14 +117,26 @@ func ParseStanza(c *caddy.Controller) (*DockerDiscovery, error) {
 			if ok && value != "" {
 				ttlStr = value
 			}
-			t, err := strconv.Atoi(ttlStr)
+			t, err := parseTTL(ttlStr)
 			if err != nil {
-				return nil, err
+				return nil, c.Err(err.Error())
 			}
-			if t < 0 || t > 3600 {
-				return nil, c.Errf("ttl must be in range [0, 3600]: %d", t)
+			dd.opts.ttl = t
+		case "reverse_ttl", "negative_ttl":
+			name := c.Val()
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return nil, c.ArgErr()
+			}
+			t, err := parseTTL(args[0])
+			if err != nil {
+				return nil, c.Errf("%s: %s", name, err)
+			}
+			if name == "reverse_ttl" {
+				dd.opts.reverseTTL = &t
+			} else {
+				dd.opts.negativeTTL = &t
 			}
-			dd.opts.ttl = uint32(t)
 		case "networks":
 			networks := []string{}
 			for c.NextArg() {
@@ -127,6 +157,202 @@ func ParseStanza(c *caddy.Controller) (*DockerDiscovery, error) {
 				return dd, c.ArgErr()
 			}
 			dd.opts.autoReverse = false
+		case "withdraw_paused":
+			if c.NextArg() {
+				return dd, c.ArgErr()
+			}
+			dd.opts.withdrawPaused = true
+		case "include", "exclude":
+			directive := c.Val()
+			filters := []*containerFilter{}
+			for c.NextArg() {
+				f, err := newContainerFilter(c.Val())
+				if err != nil {
+					return nil, c.Errf("%s: %s", directive, err)
+				}
+				filters = append(filters, f)
+			}
+			if len(filters) == 0 {
+				return nil, c.ArgErr()
+			}
+			if directive == "include" {
+				dd.opts.include = append(dd.opts.include, filters...)
+			} else {
+				dd.opts.exclude = append(dd.opts.exclude, filters...)
+			}
+		case "host_network_ip":
+			args := c.RemainingArgs()
+			if len(args) == 0 {
+				return nil, c.ArgErr()
+			}
+			var err error
+			if len(args) == 1 && args[0] == "auto" {
+				dd.opts.hostIPv4, dd.opts.hostIPv6, err = hostAddresses()
+			} else {
+				dd.opts.hostIPv4, dd.opts.hostIPv6, err = splitIPs(args)
+			}
+			if err != nil {
+				return nil, c.Errf("host_network_ip: %s", err)
+			}
+		case "publish_mode":
+			args := c.RemainingArgs()
+			if len(args) == 0 {
+				return nil, c.ArgErr()
+			}
+			switch args[0] {
+			case publishModeContainer:
+				if len(args) > 1 {
+					return nil, c.ArgErr()
+				}
+				dd.opts.publishMode = ""
+				continue
+			case publishModeHost:
+				dd.opts.publishMode = publishModeHost
+			default:
+				return nil, c.Errf("publish_mode must be one of '%s' or '%s': %s",
+					publishModeContainer, publishModeHost, args[0])
+			}
+			var err error
+			switch {
+			case len(args) == 1:
+			case len(args) == 2 && args[1] == "auto":
+				dd.opts.publishIPv4, dd.opts.publishIPv6, err = hostAddresses()
+			default:
+				dd.opts.publishIPv4, dd.opts.publishIPv6, err = splitIPs(args[1:])
+			}
+			if err != nil {
+				return nil, c.Errf("publish_mode: %s", err)
+			}
+		case "conflict":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return nil, c.ArgErr()
+			}
+			switch args[0] {
+			case conflictMerge, conflictFirstWins, conflictLastWins, conflictReject:
+				dd.opts.conflict = args[0]
+			default:
+				return nil, c.Errf("conflict policy must be one of %s, %s, %s, %s: %s",
+					conflictMerge, conflictFirstWins, conflictLastWins, conflictReject, args[0])
+			}
+		case "view":
+			v, err := dd.parseView(c)
+			if err != nil {
+				return nil, err
+			}
+			dd.opts.views = append(dd.opts.views, v)
+		case "order":
+			o, err := newAnswerOrder(c.RemainingArgs())
+			if err != nil {
+				return nil, c.Errf("order: %s", err)
+			}
+			dd.opts.order = o
+		case "any":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return nil, c.ArgErr()
+			}
+			switch args[0] {
+			case anyMinimal, anyFull:
+				dd.opts.anyMode = args[0]
+			default:
+				return nil, c.Errf("any must be %s or %s: %s", anyMinimal, anyFull, args[0])
+			}
+		case "rewrite":
+			r, err := newNameRewrite(c.RemainingArgs())
+			if err != nil {
+				return nil, c.Errf("rewrite: %s", err)
+			}
+			dd.opts.rewrites = append(dd.opts.rewrites, r)
+		case "project_zones":
+			p, err := dd.newProjectZones(c.RemainingArgs())
+			if err != nil {
+				return nil, c.Errf("project_zones: %s", err)
+			}
+			dd.opts.projectZones = append(dd.opts.projectZones, p)
+		case "dnssec_key":
+			args := c.RemainingArgs()
+			if len(args) == 0 {
+				return nil, c.ArgErr()
+			}
+			dd.opts.dnssecKeys = append(dd.opts.dnssecKeys, args...)
+		case "dnssec_denial":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return nil, c.ArgErr()
+			}
+			switch args[0] {
+			case denialNSEC, denialNSEC3:
+				dd.opts.dnssecDenial = args[0]
+			default:
+				return nil, c.Errf("dnssec_denial must be %s or %s: %s", denialNSEC, denialNSEC3, args[0])
+			}
+		case "update_server":
+			u, err := parseUpdateServer(c)
+			if err != nil {
+				return nil, err
+			}
+			dd.update = u
+		case "export_hosts":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return nil, c.ArgErr()
+			}
+			dd.exporters = append(dd.exporters, newFileExporter(args[0], exportHosts, ""))
+		case "export_zone":
+			args := c.RemainingArgs()
+			if len(args) == 0 || len(args) > 2 {
+				return nil, c.ArgErr()
+			}
+			zone := dd.Origins[primaryZoneIndex]
+			if len(args) == 2 {
+				zone = plugin.Name(args[1]).Normalize()
+				if !dd.isOrigin(zone) {
+					return nil, c.Errf("export_zone: zone %s is not one of %v", zone, dd.Origins)
+				}
+			}
+			dd.exporters = append(dd.exporters, newFileExporter(args[0], exportZone, zone))
+		case "api":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return nil, c.ArgErr()
+			}
+			if _, _, err := net.SplitHostPort(args[0]); err != nil {
+				return nil, c.Errf("api: invalid address %s: %s", args[0], err)
+			}
+			dd.api = newAPIServer(args[0], dd)
+		case "explain":
+			if c.NextArg() {
+				return dd, c.ArgErr()
+			}
+			dd.opts.explain = true
+		case "snapshot":
+			args := c.RemainingArgs()
+			if len(args) == 0 || len(args) > 2 {
+				return nil, c.ArgErr()
+			}
+			interval := defaultSnapshotInterval
+			if len(args) == 2 {
+				d, err := time.ParseDuration(args[1])
+				if err != nil || d <= 0 {
+					return nil, c.Errf("snapshot: invalid interval %s", args[1])
+				}
+				interval = d
+			}
+			dd.snapshot = newSnapshotStore(args[0], interval)
+		case "start_without_daemon":
+			args := c.RemainingArgs()
+			if len(args) > 1 {
+				return nil, c.ArgErr()
+			}
+			dd.opts.reconnectInterval = defaultReconnectInterval
+			if len(args) == 1 {
+				d, err := time.ParseDuration(args[0])
+				if err != nil || d <= 0 {
+					return nil, c.Errf("start_without_daemon: invalid interval %s", args[0])
+				}
+				dd.opts.reconnectInterval = d
+			}
 		default:
 			return nil, c.Errf("Unknown directive '%s'", c.Val())
 		}
@@ -146,14 +372,14 @@ func ParseStanza(c *caddy.Controller) (*DockerDiscovery, error) {
 	}
 	ttlVal, ok := os.LookupEnv(dockerEnvTTL)
 	if ok && ttlVal != "" {
-		t, err := strconv.Atoi(ttlVal)
+		t, err := parseTTL(ttlVal)
 		if err != nil {
-			return nil, err
+			return nil, c.Err(err.Error())
 		}
-		if t < 0 || t > 3600 {
-			return nil, c.Errf("ttl must be in range [0, 3600]: %d", t)
-		}
-		dd.opts.ttl = uint32(t)
+		dd.opts.ttl = t
+	}
+	if dd.opts.reverseTTL != nil {
+		dd.hmap.reverseTTL = dd.opts.reverseTTL
 	}
 	networkVal, ok := os.LookupEnv(dockerEnvNetworks)
 	if ok && networkVal != "" {
@@ -172,6 +398,31 @@ func ParseStanza(c *caddy.Controller) (*DockerDiscovery, error) {
 		dd.opts.fromNetworks = networks
 	}
 
+	// records of snapshot are served until docker daemon is available
+	if dd.snapshot != nil && dd.opts.reconnectInterval == 0 {
+		dd.opts.reconnectInterval = defaultReconnectInterval
+	}
+
+	if dd.update != nil {
+		if len(dd.update.zones) == 0 {
+			dd.update.zones = dd.Origins
+		}
+	}
+
+	if len(dd.opts.dnssecKeys) != 0 {
+		keys, err := parseKeyFiles(dnsserver.GetConfig(c).Root, dd.opts.dnssecKeys)
+		if err != nil {
+			return nil, c.Errf("dnssec_key: %s", err)
+		}
+		dd.signers, err = newSigners(dd.Origins, keys)
+		if err != nil {
+			return nil, c.Errf("dnssec_key: %s", err)
+		}
+		for _, s := range dd.signers {
+			s.nsec3 = dd.opts.dnssecDenial == denialNSEC3
+		}
+	}
+
 	dockerClient, err := dockerapi.NewClient(dd.opts.dockerEndpoint)
 	if err != nil || dockerClient == nil {
 		log.Errorf("[docker] create docker client: %s", err)
@@ -179,13 +430,13 @@ func ParseStanza(c *caddy.Controller) (*DockerDiscovery, error) {
 	}
 	dd.dockerClient = dockerClient
 
-	if len(dd.opts.fromNetworks) == 0 {
-		nets, err := dd.findOwnNetworks()
-		if err == nil {
-			dd.opts.fromNetworks = nets
-		}
+	if dd.opts.order != nil {
+		dd.hmap.subscribe(dd.opts.order.forget(dd.hmap))
 	}
 
+	// daemon may be unavailable yet, so networks are found on connect
+	dd.opts.ownNetworks = len(dd.opts.fromNetworks) == 0
+
 	dd.addRZones()
 
 	return dd, nil
@@ -193,11 +444,7 @@ func ParseStanza(c *caddy.Controller) (*DockerDiscovery, error) {
 
 func (dd *DockerDiscovery) addRZones() {
 	for i := range dd.Origins {
-		dot := "."
-		if dd.Origins[i] == "" || dd.Origins[i] == "." {
-			dot = ""
-		}
-		dd.rzones = append(dd.rzones, dot+dd.Origins[i])
+		dd.rzones = append(dd.rzones, rzone(dd.Origins[i]))
 	}
 }
 
@@ -259,7 +506,7 @@ func (dd *DockerDiscovery) findOwnNetworks() ([]string, error) {
 	for _, apiContainer := range containers {
 		container, err := dd.dockerClient.InspectContainerWithOptions(dockerapi.InspectContainerOptions{ID: apiContainer.ID})
 		if err != nil {
-			log.Errorf("[docker] inspect container %s: %s", container.ID[:12], err)
+			log.Errorf("[docker] inspect container %s: %s", apiContainer.ID[:12], err)
 			return nil, err
 		}
 		for name := range container.NetworkSettings.Networks {
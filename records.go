@@ -0,0 +1,160 @@
+package dockerdns
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	csm "github.com/mhmtszr/concurrent-swiss-map"
+	"github.com/miekg/dns"
+)
+
+// dockerRecordLabelPrefix starts labels with RFC 1035 record lines,
+// i.e. coredns.dockerdns.record.mx=mail.loc. 300 IN MX 10 mailhog.loc.
+const dockerRecordLabelPrefix = "coredns.dockerdns.record."
+
+// ownedRR is a label record of container.
+type ownedRR struct {
+	id string
+	rr dns.RR
+}
+
+func newRecordsMap() *csm.CsMap[string, []ownedRR] {
+	return csm.Create[string, []ownedRR](
+		csm.WithShardCount[string, []ownedRR](32),
+		csm.WithSize[string, []ownedRR](100),
+	)
+}
+
+// parseRecordLabels parses record labels of container in order of label keys.
+// Records must belong to plugin origins, types served from container
+// addresses and types changing resolution of the name are rejected.
+func (dd *DockerDiscovery) parseRecordLabels(labels map[string]string, c *ContainerData) {
+	keys := []string{}
+	for key := range labels {
+		if strings.HasPrefix(key, dockerRecordLabelPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		rr, err := dd.parseRecord(labels[key])
+		if err != nil {
+			c.notes = append(c.notes, fmt.Sprintf("label %s: %s", key, err))
+			continue
+		}
+		c.records = append(c.records, rr)
+	}
+}
+
+func (dd *DockerDiscovery) parseRecord(s string) (dns.RR, error) {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		return nil, err
+	}
+	if rr == nil {
+		return nil, fmt.Errorf("empty record")
+	}
+	switch rr.Header().Rrtype {
+	case dns.TypeA, dns.TypeAAAA, dns.TypePTR, dns.TypeSOA, dns.TypeCNAME, dns.TypeDNAME, dns.TypeNS,
+		// built from https labels
+		dns.TypeHTTPS, dns.TypeSVCB,
+		// made by the online signer
+		dns.TypeDNSKEY, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3, dns.TypeNSEC3PARAM, dns.TypeDS:
+		return nil, fmt.Errorf("record type %s is not allowed", dns.TypeToString[rr.Header().Rrtype])
+	}
+	if rr.Header().Class != dns.ClassINET {
+		return nil, fmt.Errorf("record class %s is not allowed", dns.ClassToString[rr.Header().Class])
+	}
+	rr.Header().Name = strings.ToLower(rr.Header().Name)
+	if dd.matchOrigin(rr.Header().Name) == "" {
+		return nil, fmt.Errorf("name %s is not in Origins", rr.Header().Name)
+	}
+	return rr, nil
+}
+
+// linkRecords adds label records of container.
+func (m *Map) linkRecords(info *ContainerData) {
+	added := []dns.RR{}
+	for _, rr := range info.records {
+		name := rr.Header().Name
+		owned, _ := m.extra.Load(name)
+		if !hasRR(owned, rr) {
+			added = append(added, rr)
+		}
+		m.extra.Store(name, append(owned[:len(owned):len(owned)], ownedRR{id: info.id, rr: rr}))
+		countAncestors(m.ents, name, info.id, 1)
+	}
+	m.record(nil, added)
+}
+
+// unlinkRecords removes label records of container.
+func (m *Map) unlinkRecords(info *ContainerData) {
+	removed := []dns.RR{}
+	for _, rr := range info.records {
+		name := rr.Header().Name
+		owned, _ := m.extra.Load(name)
+		res := make([]ownedRR, 0, len(owned))
+		for _, o := range owned {
+			if o.id != info.id {
+				res = append(res, o)
+			}
+		}
+		if len(res) == len(owned) {
+			continue
+		}
+		for _, o := range owned {
+			if o.id == info.id && !hasRR(res, o.rr) {
+				removed = append(removed, o.rr)
+			}
+		}
+		countAncestors(m.ents, name, info.id, len(res)-len(owned))
+		if len(res) == 0 {
+			m.extra.Delete(name)
+		} else {
+			m.extra.Store(name, res)
+		}
+	}
+	m.record(removed, nil)
+}
+
+// extraRecords returns label records of name and type owned by visible
+// containers, duplicates are dropped.
+func (m *Map) extraRecords(name string, qtype uint16, visible func(id string) bool) []dns.RR {
+	return m.filterExtraRecords(name, visible, func(rr dns.RR) bool { return rr.Header().Rrtype == qtype })
+}
+
+// allExtraRecords returns label records of name of all types owned by visible containers.
+func (m *Map) allExtraRecords(name string, visible func(id string) bool) []dns.RR {
+	return m.filterExtraRecords(name, visible, func(dns.RR) bool { return true })
+}
+
+func (m *Map) filterExtraRecords(name string, visible func(id string) bool, match func(dns.RR) bool) []dns.RR {
+	owned, _ := m.extra.Load(name)
+	var res []dns.RR
+	for _, o := range owned {
+		if !match(o.rr) || !visible(o.id) {
+			continue
+		}
+		dup := false
+		for _, rr := range res {
+			if dns.IsDuplicate(rr, o.rr) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			res = append(res, dns.Copy(o.rr))
+		}
+	}
+	return res
+}
+
+func hasRR(owned []ownedRR, rr dns.RR) bool {
+	for _, o := range owned {
+		if dns.IsDuplicate(o.rr, rr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package dockerdns
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+func TestRecordLabels(t *testing.T) {
+	dd := NewDockerDiscovery("")
+	dd.Origins = []string{"loc."}
+	dd.connected = 1
+	labels := map[string]string{
+		dockerRecordLabelPrefix + "mx":    "mail.loc. 300 IN MX 10 mailhog.loc.",
+		dockerRecordLabelPrefix + "caa":   `mail.loc. 300 IN CAA 0 issue "letsencrypt.org"`,
+		dockerRecordLabelPrefix + "out":   "mail.example.com. 300 IN MX 10 mailhog.loc.",
+		dockerRecordLabelPrefix + "a":     "mail.loc. 300 IN A 10.0.0.1",
+		dockerRecordLabelPrefix + "wrong": "mail.loc. IN MX ten mailhog.loc.",
+	}
+	one := &ContainerData{id: "one"}
+	dd.parseRecordLabels(labels, one)
+	if len(one.records) != 2 || len(one.notes) != 3 {
+		t.Fatalf("records = %v, notes = %v, want 2 records and 3 notes", one.records, one.notes)
+	}
+	two := &ContainerData{id: "two"}
+	dd.parseRecordLabels(map[string]string{dockerRecordLabelPrefix + "mx": "MAIL.loc. 300 IN MX 10 mailhog.loc."}, two)
+	dd.hmap.addContainer(one)
+	dd.hmap.addContainer(two)
+
+	query := func(qtype uint16) *dns.Msg {
+		r := new(dns.Msg)
+		r.SetQuestion("mail.loc.", qtype)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := dd.ServeDNS(context.Background(), rec, r); err != nil {
+			t.Fatalf("ServeDNS() error = %v", err)
+		}
+		return rec.Msg
+	}
+	if m := query(dns.TypeMX); m == nil || len(m.Answer) != 1 {
+		t.Fatalf("MX answer = %v, want one record of both containers", m)
+	}
+	if m := query(dns.TypeCAA); m == nil || len(m.Answer) != 1 {
+		t.Fatalf("CAA answer = %v, want one record", m)
+	}
+
+	dd.hmap.removeContainer("one")
+	if m := query(dns.TypeMX); m == nil || len(m.Answer) != 1 {
+		t.Errorf("MX answer = %v, want record of the remaining container", m)
+	}
+	dd.hmap.removeContainer("two")
+	if _, records := dd.hmap.records(); len(records) != 0 {
+		t.Errorf("records = %v, want none after containers are removed", records)
+	}
+}
+
+func TestParseRecordRejected(t *testing.T) {
+	dd := NewDockerDiscovery("")
+	dd.Origins = []string{"loc."}
+	tests := []struct {
+		name   string
+		record string
+	}{
+		{name: "a", record: "mail.loc. 300 IN A 10.0.0.1"},
+		{name: "cname", record: "mail.loc. 300 IN CNAME web.loc."},
+		{name: "https", record: "mail.loc. 300 IN HTTPS 1 . alpn=h2"},
+		{name: "svcb", record: "mail.loc. 300 IN SVCB 1 . alpn=h2"},
+		{name: "dnskey", record: "loc. 300 IN DNSKEY 257 3 13 AwEAAQ=="},
+		{name: "rrsig", record: "mail.loc. 300 IN RRSIG A 13 2 300 20300101000000 20200101000000 12345 loc. AwEAAQ=="},
+		{name: "nsec", record: "mail.loc. 300 IN NSEC web.loc. A RRSIG NSEC"},
+		{name: "nsec3", record: "mail.loc. 300 IN NSEC3 1 0 0 - 0123456789ABCDEFGHIJKLMNOPQRSTUV A"},
+		{name: "nsec3param", record: "loc. 300 IN NSEC3PARAM 1 0 0 -"},
+		{name: "ds", record: "mail.loc. 300 IN DS 12345 13 2 0123456789abcdef"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := dns.NewRR(tt.record); err != nil {
+				t.Fatalf("invalid test record: %v", err)
+			}
+			if rr, err := dd.parseRecord(tt.record); err == nil {
+				t.Errorf("parseRecord() = %v, want type error", rr)
+			}
+		})
+	}
+}
@@ -14,7 +14,7 @@ func normalizeContainerName(container *dockerapi.Container) string {
 	return strings.TrimLeft(container.Name, "/")
 }
 
-func (dd *DockerDiscovery) makeFQDNs(domains []string) []string {
+func (dd *DockerDiscovery) makeFQDNs(domains, rzones []string) []string {
 	// make new host list: domain + zone from coredns block args
 	// examples:
 	// 1:
@@ -30,10 +30,10 @@ func (dd *DockerDiscovery) makeFQDNs(domains []string) []string {
 	// if len(dd.rzones) == 0 {
 	// 	return nil, fmt.Errorf("empty rzones list")
 	// }
-	dl := make([]string, 0, len(domains)*len(dd.rzones))
+	dl := make([]string, 0, len(domains)*len(rzones))
 	for j := range domains {
-		for k := range dd.rzones {
-			name := domains[j] + dd.rzones[k]
+		for k := range rzones {
+			name := domains[j] + rzones[k]
 			if name == "" {
 				continue
 			}
@@ -60,3 +60,17 @@ func (dd *DockerDiscovery) toFQDN(name string) (string, error) {
 	}
 	return name, nil
 }
+
+// matchOrigin returns the longest origin containing name.
+func (dd *DockerDiscovery) matchOrigin(name string) string {
+	return plugin.Zones(dd.Origins).Matches(name)
+}
+
+func (dd *DockerDiscovery) isOrigin(zone string) bool {
+	for _, z := range dd.Origins {
+		if z == zone {
+			return true
+		}
+	}
+	return false
+}
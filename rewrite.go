@@ -0,0 +1,65 @@
+package dockerdns
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxLabelLength is the longest DNS label allowed by RFC 1035.
+const maxLabelLength = 63
+
+// nameRewrite replaces matches of regular expression in derived names,
+// replacement may refer to submatches as $1 or ${name}.
+type nameRewrite struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+func newNameRewrite(args []string) (*nameRewrite, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("regular expression and replacement are required")
+	}
+	re, err := regexp.Compile(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return &nameRewrite{re: re, replacement: args[1]}, nil
+}
+
+func (r *nameRewrite) String() string {
+	return r.re.String() + " " + r.replacement
+}
+
+// rewriteName applies rewrite rules in order and sanitizes the result.
+func (dd *DockerDiscovery) rewriteName(name string) string {
+	for _, r := range dd.opts.rewrites {
+		name = r.re.ReplaceAllString(name, r.replacement)
+	}
+	return sanitizeName(name)
+}
+
+// sanitizeName makes valid host name of name: letters are lowercased,
+// characters other than letters, digits and hyphens are replaced with hyphens,
+// labels are trimmed of leading and trailing hyphens and cut to 63 characters,
+// empty labels are dropped.
+func sanitizeName(name string) string {
+	labels := strings.Split(strings.ToLower(name), ".")
+	res := make([]string, 0, len(labels))
+	for _, label := range labels {
+		b := []byte(label)
+		for i, ch := range b {
+			if (ch < 'a' || ch > 'z') && (ch < '0' || ch > '9') && ch != '-' {
+				b[i] = '-'
+			}
+		}
+		label = strings.Trim(string(b), "-")
+		if len(label) > maxLabelLength {
+			label = strings.TrimRight(label[:maxLabelLength], "-")
+		}
+		if label != "" {
+			res = append(res, label)
+		}
+	}
+	return strings.Join(res, ".")
+}
@@ -0,0 +1,39 @@
+package dockerdns
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRewriteName(t *testing.T) {
+	dd := NewDockerDiscovery("")
+	for _, args := range [][]string{
+		{`^(.+)[_-]([^_-]+)[_-]([0-9]+)$`, "$2-$3.$1"},
+		{`-1\.`, "."},
+	} {
+		r, err := newNameRewrite(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dd.opts.rewrites = append(dd.opts.rewrites, r)
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "proj_web_1", want: "web.proj"},
+		{name: "proj-web-2", want: "web-2.proj"},
+		{name: "My_Service", want: "my-service"},
+		{name: "_db_", want: "db"},
+		{name: "a..b", want: "a.b"},
+		{name: strings.Repeat("x", 70), want: strings.Repeat("x", 63)},
+		{name: "___", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dd.rewriteName(tt.name); got != tt.want {
+				t.Errorf("rewriteName(%s) = %s, want %s", tt.name, got, tt.want)
+			}
+		})
+	}
+}
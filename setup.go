@@ -1,6 +1,10 @@
 package dockerdns
 
 import (
+	"errors"
+	"sync/atomic"
+	"time"
+
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
 	dockerapi "github.com/fsouza/go-dockerclient"
@@ -14,16 +18,23 @@ const (
 	defaultTTL            = 3600
 	dockerHostLabel       = "coredns.dockerdns.host"
 	dockerEnableLabel     = "coredns.dockerdns.enable"
+	dockerTTLLabel        = "coredns.dockerdns.ttl"
+
+	// maxTTL is the largest ttl allowed by RFC 2181
+	maxTTL = 1<<31 - 1
 
 	dockerIdentityLabel = "coredns.dockerdns.server"
 
 	dockerProjectLabel = "com.docker.compose.project"
 	dockerServiceLabel = "com.docker.compose.service"
+	dockerNumberLabel  = "com.docker.compose.container-number"
 
 	dockerEnvEndpoint   = "COREDNS_DOCKER_ENDPOINT"
 	dockerEnvAutoEnable = "COREDNS_DOCKER_AUTOENABLE"
 	dockerEnvNetworks   = "COREDNS_DOCKER_NETWORKS"
 	dockerEnvTTL        = "COREDNS_DOCKER_TTL"
+
+	defaultReconnectInterval = 10 * time.Second
 )
 
 func init() {
@@ -59,25 +70,43 @@ func setup(c *caddy.Controller) error {
 		return err
 	}
 
-	err = dd.scanContainers()
-	if err != nil {
-		return err
+	if dd.snapshot != nil {
+		n, err := dd.snapshot.load(dd.hmap)
+		if err != nil {
+			log.Errorf("[docker] load snapshot %s: %s", dd.snapshot.path, err)
+		} else if n != 0 {
+			log.Infof("[docker] loaded %d containers from snapshot %s", n, dd.snapshot.path)
+		}
 	}
 
 	stopChan := make(chan struct{})
 	eventChan := make(chan *dockerapi.APIEvents)
 
-	if err := dd.dockerClient.AddEventListener(eventChan); err != nil {
-		log.Errorf("[docker] AddEventListener: %s", err)
-		return err
+	if err := dd.connect(eventChan); err != nil {
+		if dd.opts.reconnectInterval == 0 {
+			return err
+		}
+		log.Warningf("[docker] docker daemon is not available, retry every %s: %s", dd.opts.reconnectInterval, err)
+		go dd.reconnect(stopChan, eventChan)
 	}
 
 	go dd.start(stopChan, eventChan)
 
+	if dd.snapshot != nil {
+		go dd.snapshot.run(dd.hmap)
+	}
+
+	if dd.api != nil {
+		// the same hooks as health plugin uses: listener of the old instance
+		// is closed on reload and restored if the new configuration fails
+		c.OnStartup(dd.api.start)
+		c.OnRestart(dd.api.stop)
+		c.OnFinalShutdown(dd.api.stop)
+		c.OnRestartFailed(dd.api.start)
+	}
+
 	c.OnShutdown(func() error {
-		close(stopChan)
-		close(eventChan)
-		log.Info("[docker] Stop event listening")
+		dd.shutdown(stopChan, eventChan)
 		return nil
 	})
 
@@ -88,3 +117,96 @@ func setup(c *caddy.Controller) error {
 	})
 	return nil
 }
+
+// connect finds own networks, scans containers, subscribes to docker events
+// and starts record sinks.
+func (dd *DockerDiscovery) connect(eventChan chan *dockerapi.APIEvents) error {
+	dd.connMu.Lock()
+	defer dd.connMu.Unlock()
+	if dd.stopped {
+		return errors.New("plugin is stopped")
+	}
+
+	if dd.opts.ownNetworks {
+		nets, err := dd.findOwnNetworks()
+		if err != nil {
+			return err
+		}
+		dd.setNetworks(nets)
+	}
+
+	err := dd.scanContainers()
+	if err != nil {
+		return err
+	}
+
+	if err := dd.dockerClient.AddEventListener(eventChan); err != nil {
+		log.Errorf("[docker] AddEventListener: %s", err)
+		return err
+	}
+	atomic.StoreInt32(&dd.connected, 1)
+
+	if dd.update != nil {
+		dd.hmap.subscribe(dd.update.enqueue)
+		_, records := dd.hmap.records()
+		go dd.update.sync(records)
+	}
+
+	for _, e := range dd.exporters {
+		e := e
+		if err := e.write(dd.hmap, dd.apex); err != nil {
+			log.Errorf("[docker] export %s file %s: %s", e.format, e.path, err)
+		}
+		dd.hmap.subscribe(func(*journalEntry) {
+			e.schedule(dd.hmap, dd.apex)
+		})
+	}
+	return nil
+}
+
+// reconnect retries connect until it succeeds or plugin is stopped.
+func (dd *DockerDiscovery) reconnect(stopChan chan struct{}, eventChan chan *dockerapi.APIEvents) {
+	ticker := time.NewTicker(dd.opts.reconnectInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+			if err := dd.connect(eventChan); err != nil {
+				log.Debugf("[docker] connect to docker daemon: %s", err)
+				continue
+			}
+			log.Info("[docker] connected to docker daemon")
+			return
+		}
+	}
+}
+
+// shutdown stops event listening and record sinks. It waits for running
+// connect, so the listener isn't added to closed eventChan.
+func (dd *DockerDiscovery) shutdown(stopChan chan struct{}, eventChan chan *dockerapi.APIEvents) {
+	dd.connMu.Lock()
+	dd.stopped = true
+	close(stopChan)
+	if dd.isConnected() {
+		dd.dockerClient.RemoveEventListener(eventChan)
+	}
+	close(eventChan)
+	dd.connMu.Unlock()
+	log.Info("[docker] Stop event listening")
+
+	if dd.update != nil {
+		dd.update.stop()
+	}
+	for _, e := range dd.exporters {
+		e.stop()
+	}
+	if dd.snapshot != nil {
+		dd.snapshot.stop(dd.hmap)
+	}
+}
+
+func (dd *DockerDiscovery) isConnected() bool {
+	return atomic.LoadInt32(&dd.connected) == 1
+}
@@ -0,0 +1,109 @@
+package dockerdns
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin/dnssec"
+	"github.com/coredns/coredns/plugin/pkg/cache"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultSignatureCapacity is the number of cached signatures per origin.
+	defaultSignatureCapacity = 10000
+
+	denialNSEC  = "nsec"
+	denialNSEC3 = "nsec3"
+)
+
+// signer keeps keys and cached signatures of one origin. Signatures are cached
+// by hash of signed record set, so they are reused until the set changes.
+type signer struct {
+	zone  string
+	keys  []*dnssec.DNSKEY
+	d     dnssec.Dnssec
+	nsec3 bool // deny existence with NSEC3 records added by the plugin instead of NSEC black lies
+}
+
+// parseKeyFiles reads BIND format key pairs, name may be given
+// with or without .key/.private extension.
+func parseKeyFiles(root string, names []string) ([]*dnssec.DNSKEY, error) {
+	keys := make([]*dnssec.DNSKEY, 0, len(names))
+	for _, name := range names {
+		base := strings.TrimSuffix(strings.TrimSuffix(name, ".key"), ".private")
+		if !filepath.IsAbs(base) && root != "" {
+			base = filepath.Join(root, base)
+		}
+		k, err := dnssec.ParseKeyFile(base+".key", base+".private")
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %s", name, err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// newSigners groups keys by their owner name, every key must belong to one of origins.
+func newSigners(origins []string, keys []*dnssec.DNSKEY) (map[string]*signer, error) {
+	signers := map[string]*signer{}
+	for _, k := range keys {
+		zone := dns.CanonicalName(k.K.Header().Name)
+		found := false
+		for _, o := range origins {
+			if o == zone {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("key %s (keyid: %d) can not sign any of the zones %v",
+				zone, k.K.KeyTag(), origins)
+		}
+		s, ok := signers[zone]
+		if !ok {
+			s = &signer{zone: zone}
+			signers[zone] = s
+		}
+		s.keys = append(s.keys, k)
+	}
+	for zone, s := range signers {
+		s.d = dnssec.New([]string{zone}, s.keys, false, nil, cache.New(defaultSignatureCapacity))
+	}
+	return signers, nil
+}
+
+// dnskey returns reply with DNSKEY records of zone.
+func (s *signer) dnskey(state request.Request, ttl uint32) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(state.Req)
+	for _, k := range s.keys {
+		key := dns.Copy(k.K)
+		key.Header().Name = s.zone
+		key.Header().Ttl = ttl
+		m.Answer = append(m.Answer, key)
+	}
+	return m
+}
+
+// sign adds signatures and NSEC records for denial of existence to reply m.
+// Negative replies already carrying NSEC3 records are signed as they are.
+func (s *signer) sign(state request.Request, m *dns.Msg, server string) *dns.Msg {
+	if !state.Do() {
+		return m
+	}
+	st := request.Request{W: state.W, Req: m, Zone: s.zone}
+	if !s.nsec3 || len(m.Answer) != 0 {
+		return s.d.Sign(st, time.Now().UTC(), server)
+	}
+	// dnssec.Sign replaces denial of negative replies with black lies, so
+	// authority records are signed as answers of a positive reply
+	rcode := m.Rcode
+	m.Answer, m.Ns, m.Rcode = m.Ns, nil, dns.RcodeSuccess
+	m = s.d.Sign(st, time.Now().UTC(), server)
+	m.Answer, m.Ns, m.Rcode = nil, m.Answer, rcode
+	return m
+}
@@ -0,0 +1,198 @@
+package dockerdns
+
+import (
+	"context"
+	"crypto/ed25519"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+// writeTestKey generates ed25519 key pair of zone in BIND format.
+func writeTestKey(t *testing.T, dir, zone string, flags uint16) string {
+	k := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     flags,
+		Protocol:  3,
+		Algorithm: dns.ED25519,
+	}
+	priv, err := k.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := filepath.Join(dir, "K"+zone+"+015+test")
+	if err := os.WriteFile(base+".key", []byte(k.String()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(base+".private", []byte(k.PrivateKeyString(priv.(ed25519.PrivateKey))), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return base
+}
+
+func TestSignedAnswers(t *testing.T) {
+	dir := t.TempDir()
+	keys, err := parseKeyFiles("", []string{writeTestKey(t, dir, "loc.", 257) + ".key"})
+	if err != nil {
+		t.Fatalf("parseKeyFiles() error = %v", err)
+	}
+	if _, err := newSigners([]string{"moc."}, keys); err == nil {
+		t.Errorf("newSigners() accepted key of foreign zone")
+	}
+
+	dd := NewDockerDiscovery("")
+	dd.Origins = []string{"loc."}
+	dd.connected = 1
+	dd.signers, err = newSigners(dd.Origins, keys)
+	if err != nil {
+		t.Fatalf("newSigners() error = %v", err)
+	}
+	dd.hmap.addContainer(&ContainerData{id: "web", hosts: []string{"web.loc."}, ipv4: []net.IP{parseIP("172.28.0.2")}})
+
+	tests := []struct {
+		name   string
+		qname  string
+		qtype  uint16
+		rcode  int
+		answer uint16
+	}{
+		{name: "A", qname: "web.loc.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, answer: dns.TypeA},
+		{name: "DNSKEY", qname: "loc.", qtype: dns.TypeDNSKEY, rcode: dns.RcodeSuccess, answer: dns.TypeDNSKEY},
+		// NSEC black lies turn NXDOMAIN into NODATA
+		{name: "missing", qname: "db.loc.", qtype: dns.TypeA, rcode: dns.RcodeSuccess},
+		{name: "nodata", qname: "web.loc.", qtype: dns.TypeAAAA, rcode: dns.RcodeSuccess},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := new(dns.Msg)
+			r.SetQuestion(tt.qname, tt.qtype)
+			r.SetEdns0(4096, true)
+			rec := dnstest.NewRecorder(&test.ResponseWriter{})
+			if _, err := dd.ServeDNS(context.Background(), rec, r); err != nil {
+				t.Fatalf("ServeDNS() error = %v", err)
+			}
+			m := rec.Msg
+			if m == nil || m.Rcode != tt.rcode {
+				t.Fatalf("ServeDNS() = %v, want rcode %d", m, tt.rcode)
+			}
+			section := m.Answer
+			if tt.answer == 0 {
+				section = m.Ns
+			}
+			sigs := 0
+			for _, rr := range section {
+				if _, ok := rr.(*dns.RRSIG); ok {
+					sigs++
+				}
+			}
+			if sigs == 0 {
+				t.Errorf("ServeDNS() = %v, want signed reply", m)
+			}
+		})
+	}
+}
+
+func TestNSEC3Denial(t *testing.T) {
+	dir := t.TempDir()
+	keys, err := parseKeyFiles("", []string{writeTestKey(t, dir, "loc.", 257)})
+	if err != nil {
+		t.Fatalf("parseKeyFiles() error = %v", err)
+	}
+	dd := NewDockerDiscovery("")
+	dd.Origins = []string{"loc."}
+	dd.connected = 1
+	dd.signers, err = newSigners(dd.Origins, keys)
+	if err != nil {
+		t.Fatalf("newSigners() error = %v", err)
+	}
+	dd.signers["loc."].nsec3 = true
+	dd.hmap.addContainer(&ContainerData{id: "web", hosts: []string{"web.loc."}, ipv4: []net.IP{parseIP("172.28.0.2")}})
+	dd.hmap.addContainer(&ContainerData{id: "db", hosts: []string{"db.proj.loc."}, ipv4: []net.IP{parseIP("172.28.0.3")}})
+
+	tests := []struct {
+		name      string
+		qname     string
+		qtype     uint16
+		rcode     int
+		match     string   // name matched by NSEC3
+		types     []uint16 // types of matched name
+		cover     []string // names covered by NSEC3
+		wantParam bool
+	}{
+		{name: "nodata", qname: "web.loc.", qtype: dns.TypeAAAA, match: "web.loc.", types: []uint16{dns.TypeA, dns.TypeRRSIG}},
+		{name: "empty non-terminal", qname: "proj.loc.", qtype: dns.TypeA, match: "proj.loc."},
+		{
+			name: "nxdomain", qname: "a.b.loc.", qtype: dns.TypeA, rcode: dns.RcodeNameError, match: "loc.",
+			types: []uint16{dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeDNSKEY, dns.TypeNSEC3PARAM},
+			cover: []string{"b.loc.", "*.loc."},
+		},
+		{name: "nxdomain below non-terminal", qname: "cache.proj.loc.", qtype: dns.TypeA, rcode: dns.RcodeNameError, match: "proj.loc.", cover: []string{"cache.proj.loc.", "*.proj.loc."}},
+		{name: "nsec3param", qname: "loc.", qtype: dns.TypeNSEC3PARAM, wantParam: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := new(dns.Msg)
+			r.SetQuestion(tt.qname, tt.qtype)
+			r.SetEdns0(4096, true)
+			rec := dnstest.NewRecorder(&test.ResponseWriter{})
+			if _, err := dd.ServeDNS(context.Background(), rec, r); err != nil {
+				t.Fatalf("ServeDNS() error = %v", err)
+			}
+			m := rec.Msg
+			if m == nil || m.Rcode != tt.rcode {
+				t.Fatalf("ServeDNS() = %v, want rcode %d", m, tt.rcode)
+			}
+			if tt.wantParam {
+				if len(m.Answer) == 0 || m.Answer[0].Header().Rrtype != dns.TypeNSEC3PARAM {
+					t.Errorf("ServeDNS() = %v, want NSEC3PARAM", m)
+				}
+				return
+			}
+			var nsec3 []*dns.NSEC3
+			sigs := map[string]*dns.RRSIG{}
+			for _, rr := range m.Ns {
+				switch r := rr.(type) {
+				case *dns.NSEC3:
+					nsec3 = append(nsec3, r)
+				case *dns.RRSIG:
+					sigs[r.Hdr.Name+"/"+dns.TypeToString[r.TypeCovered]] = r
+				case *dns.NSEC:
+					t.Errorf("ServeDNS() has NSEC record %s", r)
+				}
+			}
+			matched := false
+			for _, rr := range nsec3 {
+				sig, ok := sigs[rr.Hdr.Name+"/NSEC3"]
+				if !ok {
+					t.Errorf("NSEC3 %s is not signed", rr)
+				} else if err := sig.Verify(keys[0].K, []dns.RR{rr}); err != nil {
+					t.Errorf("signature of %s: %v", rr, err)
+				}
+				if rr.Match(tt.match) {
+					matched = true
+					if !reflect.DeepEqual(rr.TypeBitMap, tt.types) && (len(rr.TypeBitMap) != 0 || len(tt.types) != 0) {
+						t.Errorf("NSEC3 of %s types = %v, want %v", tt.match, rr.TypeBitMap, tt.types)
+					}
+				}
+			}
+			if !matched {
+				t.Errorf("ServeDNS() = %v, want NSEC3 matching %s", m, tt.match)
+			}
+			for _, name := range tt.cover {
+				covered := false
+				for _, rr := range nsec3 {
+					covered = covered || rr.Cover(name)
+				}
+				if !covered {
+					t.Errorf("ServeDNS() = %v, want NSEC3 covering %s", m, name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package dockerdns
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// defaultSnapshotInterval is the period of snapshot saving.
+const defaultSnapshotInterval = 30 * time.Second
+
+// snapshotData is the format of snapshot file.
+type snapshotData struct {
+	Serial     uint32              `json:"serial"`
+	Time       time.Time           `json:"time"`
+	Containers []snapshotContainer `json:"containers"`
+}
+
+// snapshotContainer keeps every field of ContainerData affecting answers,
+// so restored containers are served and filtered as scanned ones.
+// Keys of fields shared with containerView are the same, so snapshots
+// of older versions are still loaded.
+type snapshotContainer struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	Hostname string            `json:"hostname,omitempty"`
+	Project  string            `json:"project,omitempty"`
+	Service  string            `json:"service,omitempty"`
+	Number   string            `json:"number,omitempty"`
+	Networks []string          `json:"networks,omitempty"`
+	IPv4     []net.IP          `json:"ipv4,omitempty"`
+	IPv6     []net.IP          `json:"ipv6,omitempty"`
+	Hosts    []string          `json:"hosts"`
+	Shared   []string          `json:"shared,omitempty"`
+	TTL      *uint32           `json:"ttl,omitempty"`
+	Zones    []string          `json:"zones,omitempty"`
+	HTTPS    *snapshotHTTPS    `json:"https,omitempty"`
+	Records  []string          `json:"records,omitempty"`
+	Weight   *uint32           `json:"weight,omitempty"`
+	Priority uint32            `json:"priority,omitempty"`
+	Image    string            `json:"image,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Bindings []string          `json:"bindings,omitempty"`
+}
+
+type snapshotHTTPS struct {
+	ALPN []string `json:"alpn,omitempty"`
+	Port uint16   `json:"port,omitempty"`
+	ECH  []byte   `json:"ech,omitempty"`
+}
+
+func (c *ContainerData) snapshot() snapshotContainer {
+	records := make([]string, 0, len(c.records))
+	for _, rr := range c.records {
+		records = append(records, rr.String())
+	}
+	var https *snapshotHTTPS
+	if c.https != nil {
+		https = &snapshotHTTPS{ALPN: c.https.alpn, Port: c.https.port, ECH: c.https.ech}
+	}
+	return snapshotContainer{
+		ID:       c.id,
+		Name:     c.name,
+		Hostname: c.hostname,
+		Project:  c.project,
+		Service:  c.service,
+		Number:   c.number,
+		Networks: c.networks,
+		IPv4:     c.ipv4,
+		IPv6:     c.ipv6,
+		Hosts:    c.hosts,
+		Shared:   c.shared,
+		TTL:      c.ttl,
+		Zones:    c.zones,
+		HTTPS:    https,
+		Records:  records,
+		Weight:   c.weight,
+		Priority: c.priority,
+		Image:    c.image,
+		Labels:   c.labels,
+		Bindings: c.bindings,
+	}
+}
+
+// container returns stale container restored from snapshot.
+func (v snapshotContainer) container() *ContainerData {
+	records := []dns.RR{}
+	for _, line := range v.Records {
+		if rr, err := dns.NewRR(line); err == nil && rr != nil {
+			records = append(records, rr)
+		}
+	}
+	var https *httpsParams
+	if v.HTTPS != nil {
+		https = &httpsParams{alpn: v.HTTPS.ALPN, port: v.HTTPS.Port, ech: v.HTTPS.ECH}
+	}
+	return &ContainerData{
+		id:       v.ID,
+		name:     v.Name,
+		hostname: v.Hostname,
+		project:  v.Project,
+		service:  v.Service,
+		number:   v.Number,
+		networks: v.Networks,
+		ipv4:     v.IPv4,
+		ipv6:     v.IPv6,
+		hosts:    v.Hosts,
+		shared:   v.Shared,
+		ttl:      v.TTL,
+		zones:    v.Zones,
+		https:    https,
+		records:  records,
+		weight:   v.Weight,
+		priority: v.Priority,
+		image:    v.Image,
+		labels:   v.Labels,
+		bindings: v.Bindings,
+		stale:    true,
+	}
+}
+
+// snapshotStore periodically persists tracked containers, so records
+// can be served right after restart before containers are scanned.
+type snapshotStore struct {
+	path     string
+	interval time.Duration
+
+	mu    sync.Mutex
+	saved uint32 // serial of the latest saved snapshot
+
+	stopOnce sync.Once
+	stopChan chan struct{}
+}
+
+func newSnapshotStore(path string, interval time.Duration) *snapshotStore {
+	return &snapshotStore{
+		path:     path,
+		interval: interval,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// load adds containers from snapshot file to m, they are marked stale
+// until containers are scanned. Serial of m continues after the saved one.
+func (s *snapshotStore) load(m *Map) (int, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	snap := snapshotData{}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return 0, err
+	}
+	// serials of restored records follow the saved one
+	m.advanceSerial(snap.Serial)
+	for _, v := range snap.Containers {
+		m.addContainer(v.container())
+	}
+	return len(snap.Containers), nil
+}
+
+func (s *snapshotStore) save(m *Map) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.mu.Lock()
+	serial := m.serial
+	containers := []snapshotContainer{}
+	m.ids.Range(func(_ string, c *ContainerData) bool {
+		containers = append(containers, c.snapshot())
+		return false
+	})
+	m.mu.Unlock()
+
+	data, err := json.Marshal(snapshotData{
+		Serial:     serial,
+		Time:       time.Now(),
+		Containers: containers,
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return err
+	}
+	s.saved = serial
+	return nil
+}
+
+// run saves snapshot on every interval if records were changed.
+func (s *snapshotStore) run(m *Map) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			saved := s.saved
+			s.mu.Unlock()
+			if m.currentSerial() == saved {
+				continue
+			}
+			if err := s.save(m); err != nil {
+				log.Errorf("[docker] save snapshot %s: %s", s.path, err)
+			}
+		}
+	}
+}
+
+// stop saves the final snapshot and stops periodic saving.
+func (s *snapshotStore) stop(m *Map) {
+	s.stopOnce.Do(func() {
+		close(s.stopChan)
+		if err := s.save(m); err != nil {
+			log.Errorf("[docker] save snapshot %s: %s", s.path, err)
+		}
+	})
+}
+
+// pruneStale removes containers loaded from snapshot and not found by scan.
+func (dd *DockerDiscovery) pruneStale() {
+	stale := []string{}
+	dd.hmap.ids.Range(func(id string, c *ContainerData) bool {
+		if c.stale {
+			stale = append(stale, id)
+		}
+		return false
+	})
+	for _, id := range stale {
+		dd.hmap.removeContainer(id)
+		dd.decisions.Delete(id)
+	}
+	if len(stale) != 0 {
+		log.Infof("[docker] removed %d stale containers of snapshot", len(stale))
+	}
+}
@@ -0,0 +1,146 @@
+package dockerdns
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+func TestSnapshot(t *testing.T) {
+	s := newSnapshotStore(filepath.Join(t.TempDir(), "snapshot.json"), time.Minute)
+	src := newTestMap(conflictMerge)
+	src.addContainer(&ContainerData{id: "web", name: "web", hosts: []string{"web.loc."}, ipv4: []net.IP{parseIP("172.28.0.2")}})
+	if err := s.save(src); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+
+	dd := NewDockerDiscovery("")
+	n, err := s.load(dd.hmap)
+	if err != nil || n != 1 {
+		t.Fatalf("load() = %d, %v, want 1 container", n, err)
+	}
+	if saved := src.currentSerial(); !serialLess(saved, dd.hmap.currentSerial()) {
+		t.Errorf("loaded serial = %d, want after saved %d", dd.hmap.currentSerial(), saved)
+	}
+	ips, ok := dd.hmap.name4.Load("web.loc.")
+	if !ok || len(ips) != 1 || !ips[0].Equal(parseIP("172.28.0.2")) {
+		t.Errorf("loaded name4 = %v, want 172.28.0.2", ips)
+	}
+	if c, _ := dd.hmap.ids.Load("web"); c == nil || !c.stale {
+		t.Errorf("loaded container = %+v, want stale", c)
+	}
+
+	dd.pruneStale()
+	if dd.hmap.name4.Has("web.loc.") {
+		t.Errorf("stale container was not pruned")
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	dd := NewDockerDiscovery("")
+	dd.Origins = []string{"internal.loc.", "public.loc."}
+	dd.addRZones()
+	dd.opts.byDomain = true
+	ttl, weight := uint32(60), uint32(5)
+	src := &ContainerData{
+		id:       "web",
+		name:     "web",
+		project:  "proj",
+		service:  "web",
+		number:   "1",
+		networks: []string{"frontend"},
+		ipv4:     []net.IP{parseIP("172.28.0.2")},
+		ipv6:     []net.IP{parseIP("fd00::2")},
+		ttl:      &ttl,
+		zones:    parseZonesLabel("public.loc"),
+		https:    &httpsParams{alpn: []string{"h2"}, port: 8443, ech: []byte{1, 2, 3}},
+		records:  []dns.RR{test.TXT(`web.public.loc. 60 IN TXT "v=1"`)},
+		weight:   &weight,
+		priority: 1,
+		image:    "nginx:latest",
+		labels:   map[string]string{"team": "web"},
+		bindings: []string{"0.0.0.0"},
+	}
+	dd.resolveHosts(src)
+	m := newTestMap(conflictMerge)
+	m.addContainer(src)
+	s := newSnapshotStore(filepath.Join(t.TempDir(), "snapshot.json"), time.Minute)
+	if err := s.save(m); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+
+	restored := newTestMap(conflictMerge)
+	if _, err := s.load(restored); err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	c, ok := restored.ids.Load("web")
+	if !ok {
+		t.Fatalf("container is not restored")
+	}
+	got, _ := json.Marshal(c.snapshot())
+	want, _ := json.Marshal(src.snapshot())
+	if string(got) != string(want) {
+		t.Errorf("restored container = %s, want %s", got, want)
+	}
+	if !c.stale {
+		t.Errorf("restored container is not stale")
+	}
+	// view filters match restored containers before the scan
+	for _, expr := range []string{"label=team=web", "image=nginx:*"} {
+		f, err := newContainerFilter(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !f.MatchData(c) {
+			t.Errorf("filter %s doesn't match restored container", expr)
+		}
+	}
+	// zones label still limits names derived again
+	hosts := c.hosts
+	dd.resolveHosts(c)
+	if !reflect.DeepEqual(c.hosts, hosts) || !reflect.DeepEqual(hosts, []string{"web.public.loc."}) {
+		t.Errorf("hosts = %v, want %v", c.hosts, []string{"web.public.loc."})
+	}
+}
+
+func TestSetupSnapshotWithoutDaemon(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	src := newTestMap(conflictMerge)
+	src.addContainer(&ContainerData{id: "web", name: "web", hosts: []string{"web.loc."}, ipv4: []net.IP{parseIP("172.28.0.2")}})
+	if err := newSnapshotStore(path, time.Minute).save(src); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+
+	c := caddy.NewTestController("dns", `docker loc {
+		endpoint unix://`+filepath.Join(t.TempDir(), "docker.sock")+`
+		snapshot `+path+` 1h
+	}`)
+	c.ServerBlockKeys = []string{"loc"}
+	if err := setup(c); err != nil {
+		t.Fatalf("setup() error = %v, want records of snapshot served without daemon", err)
+	}
+	dd := dnsserver.GetConfig(c).Plugin[0](nil).(*DockerDiscovery)
+	if dd.opts.reconnectInterval != defaultReconnectInterval {
+		t.Errorf("reconnect interval = %s, want %s", dd.opts.reconnectInterval, defaultReconnectInterval)
+	}
+
+	r := new(dns.Msg)
+	r.SetQuestion("web.loc.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := dd.ServeDNS(context.Background(), rec, r); err != nil {
+		t.Fatalf("ServeDNS() error = %v", err)
+	}
+	if rec.Msg == nil || len(rec.Msg.Answer) != 1 {
+		t.Errorf("ServeDNS() = %v, want web.loc. of snapshot", rec.Msg)
+	}
+}
@@ -0,0 +1,117 @@
+package dockerdns
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	dockerHTTPSAlpnLabel = "coredns.dockerdns.https.alpn"
+	dockerHTTPSPortLabel = "coredns.dockerdns.https.port"
+	dockerHTTPSECHLabel  = "coredns.dockerdns.https.ech"
+)
+
+// httpsParams are service parameters of HTTPS and SVCB records of container.
+type httpsParams struct {
+	alpn []string
+	port uint16
+	ech  []byte
+}
+
+// parseHTTPSLabels returns nil if container has no https labels.
+func parseHTTPSLabels(labels map[string]string) (*httpsParams, error) {
+	p := &httpsParams{}
+	found := false
+	if val, ok := labels[dockerHTTPSAlpnLabel]; ok {
+		found = true
+		p.alpn = splitLabelList(val)
+		if len(p.alpn) == 0 {
+			return nil, fmt.Errorf("label %s: empty alpn list", dockerHTTPSAlpnLabel)
+		}
+	}
+	if val, ok := labels[dockerHTTPSPortLabel]; ok {
+		found = true
+		port, err := strconv.ParseUint(val, 10, 16)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("label %s: invalid port: %s", dockerHTTPSPortLabel, val)
+		}
+		p.port = uint16(port)
+	}
+	if val, ok := labels[dockerHTTPSECHLabel]; ok {
+		found = true
+		ech, err := base64.StdEncoding.DecodeString(val)
+		if err != nil || len(ech) == 0 {
+			return nil, fmt.Errorf("label %s: invalid base64 ECHConfigList", dockerHTTPSECHLabel)
+		}
+		p.ech = ech
+	}
+	if !found {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// svcb returns service mode record of host pointing to itself,
+// address hints are addresses of the container.
+func (p *httpsParams) svcb(host string, ttl uint32, ipv4, ipv6 []net.IP) dns.SVCB {
+	r := dns.SVCB{
+		Hdr:      dns.RR_Header{Name: host, Rrtype: dns.TypeSVCB, Class: dns.ClassINET, Ttl: ttl},
+		Priority: 1,
+		Target:   ".",
+	}
+	// keys must be in ascending order
+	if len(p.alpn) != 0 {
+		r.Value = append(r.Value, &dns.SVCBAlpn{Alpn: p.alpn})
+	}
+	if p.port != 0 {
+		r.Value = append(r.Value, &dns.SVCBPort{Port: p.port})
+	}
+	if len(ipv4) != 0 {
+		r.Value = append(r.Value, &dns.SVCBIPv4Hint{Hint: ipv4})
+	}
+	if len(p.ech) != 0 {
+		r.Value = append(r.Value, &dns.SVCBECHConfig{ECH: p.ech})
+	}
+	if len(ipv6) != 0 {
+		r.Value = append(r.Value, &dns.SVCBIPv6Hint{Hint: ipv6})
+	}
+	return r
+}
+
+// svcbRecords answers HTTPS and SVCB queries for host with one record
+// per publishing container visible in view having https labels.
+func (dd *DockerDiscovery) svcbRecords(v *view, host string, qtype uint16) []dns.RR {
+	var res []dns.RR
+	seen := map[string]struct{}{}
+	ttl := dd.hmap.nameTTL(host)
+	selected4, selected6 := dd.addresses(v, host, false), dd.addresses(v, host, true)
+	for _, info := range dd.hmap.publishers(host) {
+		if info.https == nil || !v.visible(info) {
+			continue
+		}
+		// hints are addresses of A and AAAA answers, publishers
+		// not chosen by weight or drained aren't answered
+		ipv4, ipv6 := dd.containerIPs(v, info)
+		ipv4, ipv6 = commonIPs(ipv4, selected4), commonIPs(ipv6, selected6)
+		if len(ipv4) == 0 && len(ipv6) == 0 {
+			continue
+		}
+		r := info.https.svcb(host, ttl, ipv4, ipv6)
+		var rr dns.RR = &r
+		if qtype == dns.TypeHTTPS {
+			r.Hdr.Rrtype = dns.TypeHTTPS
+			rr = &dns.HTTPS{SVCB: r}
+		}
+		key := rr.String()
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		res = append(res, rr)
+	}
+	return res
+}
@@ -0,0 +1,60 @@
+package dockerdns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+func TestSVCBRecords(t *testing.T) {
+	https, err := parseHTTPSLabels(map[string]string{
+		dockerHTTPSAlpnLabel: "h2,h3",
+		dockerHTTPSPortLabel: "8443",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dd := NewDockerDiscovery("")
+	dd.Origins = []string{"loc."}
+	dd.opts.ttl = 30
+	dd.hmap.addContainer(&ContainerData{
+		id:    "web",
+		hosts: []string{"web.loc."},
+		ipv4:  []net.IP{parseIP("172.28.0.2")},
+		ipv6:  []net.IP{parseIP("fd00::2")},
+		https: https,
+	})
+
+	tests := []struct {
+		qtype uint16
+		want  string
+	}{
+		{qtype: dns.TypeHTTPS, want: `web.loc.	30	IN	HTTPS	1 . alpn="h2,h3" port="8443" ipv4hint="172.28.0.2" ipv6hint="fd00::2"`},
+		{qtype: dns.TypeSVCB, want: `web.loc.	30	IN	SVCB	1 . alpn="h2,h3" port="8443" ipv4hint="172.28.0.2" ipv6hint="fd00::2"`},
+	}
+	for _, tt := range tests {
+		t.Run(dns.TypeToString[tt.qtype], func(t *testing.T) {
+			r := new(dns.Msg)
+			r.SetQuestion("web.loc.", tt.qtype)
+			rec := dnstest.NewRecorder(&test.ResponseWriter{})
+			if _, err := dd.ServeDNS(context.Background(), rec, r); err != nil {
+				t.Fatalf("ServeDNS() error = %v", err)
+			}
+			if rec.Msg == nil || len(rec.Msg.Answer) != 1 {
+				t.Fatalf("ServeDNS() = %v, want one record", rec.Msg)
+			}
+			if got := rec.Msg.Answer[0].String(); got != tt.want {
+				t.Errorf("answer = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := parseHTTPSLabels(map[string]string{dockerHTTPSPortLabel: "http"}); err == nil || !strings.Contains(err.Error(), dockerHTTPSPortLabel) {
+		t.Errorf("parseHTTPSLabels() error = %v, want invalid port", err)
+	}
+}
@@ -0,0 +1,108 @@
+package dockerdns
+
+import (
+	"net"
+
+	"github.com/coredns/coredns/plugin/transfer"
+	"github.com/miekg/dns"
+)
+
+// Transfer implements transfer.Transferer. It streams current records of zone
+// bracketed with SOA, or only changes made after serial if journal has them.
+func (dd *DockerDiscovery) Transfer(zone string, serial uint32) (<-chan []dns.RR, error) {
+	if !dd.isOrigin(zone) {
+		return nil, transfer.ErrNotAuthoritative
+	}
+
+	ch := make(chan []dns.RR)
+	if serial != 0 {
+		entries, current, ok := dd.hmap.changes(serial)
+		if ok {
+			go func() {
+				defer close(ch)
+				apex := dd.soa(zone, current)
+				if !serialLess(serial, current) {
+					ch <- []dns.RR{apex}
+					return
+				}
+				// RFC 1995: each difference sequence is the old SOA with removed
+				// records followed by the new SOA with added records
+				ch <- []dns.RR{apex}
+				prev := serial
+				for _, e := range entries {
+					rrs := []dns.RR{dd.soa(zone, prev)}
+					rrs = append(rrs, dd.zoneRecords(zone, e.removed)...)
+					rrs = append(rrs, dd.soa(zone, e.serial))
+					rrs = append(rrs, dd.zoneRecords(zone, e.added)...)
+					ch <- rrs
+					prev = e.serial
+				}
+				ch <- []dns.RR{apex}
+			}()
+			return ch, nil
+		}
+	}
+
+	current, records := dd.hmap.records()
+	go func() {
+		defer close(ch)
+		apex := dd.soa(zone, current)
+		if serial != 0 && !serialLess(serial, current) {
+			ch <- []dns.RR{apex}
+			return
+		}
+		ch <- []dns.RR{apex}
+		ch <- append(dd.apexNS(zone), dd.zoneRecords(zone, records)...)
+		ch <- []dns.RR{apex}
+	}()
+	return ch, nil
+}
+
+// zoneRecords returns copies of records belonging to zone.
+func (dd *DockerDiscovery) zoneRecords(zone string, records []dns.RR) []dns.RR {
+	rrs := make([]dns.RR, 0, len(records))
+	for _, rr := range records {
+		if !dns.IsSubDomain(zone, rr.Header().Name) {
+			continue
+		}
+		// records of a child origin belong to it
+		if z := dd.matchOrigin(rr.Header().Name); z != zone {
+			continue
+		}
+		rrs = append(rrs, dns.Copy(rr))
+	}
+	return rrs
+}
+
+// apexNS returns NS record of zone pointing to the SOA MNAME followed by
+// its addresses, the same records are answered to queries.
+func (dd *DockerDiscovery) apexNS(zone string) []dns.RR {
+	ns := nsName(zone)
+	ipv4, ipv6 := dd.nsAddresses(zone)
+	rrs := []dns.RR{nsRecord(zone, dd.opts.ttl)}
+	rrs = append(rrs, a(ns, dd.opts.ttl, ipv4)...)
+	return append(rrs, aaaa(ns, dd.opts.ttl, ipv6)...)
+}
+
+// apex returns SOA of zone followed by apex NS records.
+func (dd *DockerDiscovery) apex(zone string, serial uint32) []dns.RR {
+	return append([]dns.RR{dd.soa(zone, serial)}, dd.apexNS(zone)...)
+}
+
+// nsAddresses returns addresses of the nameserver of zone: publish_mode or
+// host_network_ip ones if set, otherwise addresses of interfaces coredns runs on.
+func (dd *DockerDiscovery) nsAddresses(zone string) ([]net.IP, []net.IP) {
+	ipv4, ipv6 := dd.externalAddresses()
+	if len(ipv4) == 0 && len(ipv6) == 0 {
+		var err error
+		if ipv4, ipv6, err = hostAddresses(); err != nil {
+			log.Warningf("[docker] addresses of nameserver %s: %s", nsName(zone), err)
+		}
+	}
+	return ipv4, ipv6
+}
+
+// serialLess reports whether serial a precedes b in RFC 1982 serial number arithmetic.
+func serialLess(a, b uint32) bool {
+	return a != b && int32(b-a) > 0
+}
@@ -0,0 +1,166 @@
+package dockerdns
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/plugin/transfer"
+	"github.com/miekg/dns"
+)
+
+func setupTransferDD(t *testing.T) *DockerDiscovery {
+	dd := NewDockerDiscovery("")
+	dd.Origins = []string{"loc.", "28.172.in-addr.arpa."}
+	dd.opts.autoReverse = true
+	dd.opts.ttl = 30
+	dd.opts.hostIPv4 = []net.IP{parseIP("10.0.0.53")}
+	return dd
+}
+
+func readTransfer(t *testing.T, dd *DockerDiscovery, zone string, serial uint32) []dns.RR {
+	ch, err := dd.Transfer(zone, serial)
+	if err != nil {
+		t.Fatalf("Transfer() error = %v", err)
+	}
+	rrs := []dns.RR{}
+	for records := range ch {
+		rrs = append(rrs, records...)
+	}
+	return rrs
+}
+
+func TestTransfer(t *testing.T) {
+	dd := setupTransferDD(t)
+	if _, err := dd.Transfer("example.org.", 0); err != transfer.ErrNotAuthoritative {
+		t.Errorf("Transfer() error = %v, want %v", err, transfer.ErrNotAuthoritative)
+	}
+
+	dd.hmap.addContainer(&ContainerData{id: "web", hosts: []string{"web.loc."}, ipv4: []net.IP{parseIP("172.28.0.2")}})
+	start := dd.hmap.currentSerial()
+	dd.hmap.addContainer(&ContainerData{id: "db", hosts: []string{"db.loc."}, ipv4: []net.IP{parseIP("172.28.0.3")}})
+	dd.hmap.removeContainer("web")
+	if got := dd.hmap.currentSerial(); got != start+2 {
+		t.Fatalf("serial = %d, want %d", got, start+2)
+	}
+
+	axfr := readTransfer(t, dd, "loc.", 0)
+	if len(axfr) != 5 {
+		t.Fatalf("AXFR = %v, want SOA, NS, ns.dns.loc. A, db.loc. A, SOA", axfr)
+	}
+	if ns, ok := axfr[1].(*dns.NS); !ok || ns.Hdr.Name != "loc." || ns.Ns != "ns.dns.loc." {
+		t.Errorf("AXFR record = %v, want loc. NS ns.dns.loc.", axfr[1])
+	}
+	if a, ok := axfr[2].(*dns.A); !ok || a.Hdr.Name != "ns.dns.loc." || !a.A.Equal(parseIP("10.0.0.53")) {
+		t.Errorf("AXFR record = %v, want ns.dns.loc. A 10.0.0.53", axfr[2])
+	}
+	if a, ok := axfr[3].(*dns.A); !ok || a.Hdr.Name != "db.loc." || a.Hdr.Ttl != 30 {
+		t.Errorf("AXFR record = %v, want db.loc. A with ttl 30", axfr[3])
+	}
+
+	reverse := readTransfer(t, dd, "28.172.in-addr.arpa.", 0)
+	if len(reverse) != 5 || reverse[1].Header().Rrtype != dns.TypeNS || reverse[3].Header().Rrtype != dns.TypePTR {
+		t.Errorf("reverse AXFR = %v, want SOA, NS, A, PTR, SOA", reverse)
+	}
+
+	ixfr := readTransfer(t, dd, "loc.", start)
+	// SOA, [SOA start, SOA start+1, add db], [SOA start+1, del web, SOA start+2], SOA
+	if len(ixfr) != 8 {
+		t.Fatalf("IXFR = %v, want 8 records", ixfr)
+	}
+	if ixfr[3].Header().Name != "db.loc." || ixfr[5].Header().Name != "web.loc." {
+		t.Errorf("IXFR = %v, want db.loc. added and web.loc. removed", ixfr)
+	}
+
+	upToDate := readTransfer(t, dd, "loc.", start+2)
+	if len(upToDate) != 1 || upToDate[0].(*dns.SOA).Serial != start+2 {
+		t.Errorf("IXFR of up to date zone = %v, want single SOA", upToDate)
+	}
+
+	fallback := readTransfer(t, dd, "loc.", start-10)
+	if len(fallback) != len(axfr) {
+		t.Errorf("IXFR fallback = %v, want AXFR %v", fallback, axfr)
+	}
+}
+
+func TestTransferSerialWrap(t *testing.T) {
+	dd := setupTransferDD(t)
+	dd.hmap.serial = 1<<32 - 2
+	start := dd.hmap.currentSerial()
+	dd.hmap.addContainer(&ContainerData{id: "web", hosts: []string{"web.loc."}, ipv4: []net.IP{parseIP("172.28.0.2")}})
+	dd.hmap.addContainer(&ContainerData{id: "db", hosts: []string{"db.loc."}, ipv4: []net.IP{parseIP("172.28.0.3")}})
+	current := dd.hmap.currentSerial()
+	if current != 1 {
+		t.Fatalf("serial = %d, want 1 after wrap skipping 0", current)
+	}
+	if !serialLess(start, current) || serialLess(current, start) {
+		t.Errorf("serialLess(%d, %d) is wrong after wrap", start, current)
+	}
+
+	ixfr := readTransfer(t, dd, "loc.", start)
+	// SOA, [SOA start, SOA start+1, add web], [SOA start+1, SOA 1, add db], SOA
+	if len(ixfr) != 8 {
+		t.Fatalf("IXFR = %v, want 8 records", ixfr)
+	}
+	upToDate := readTransfer(t, dd, "loc.", current)
+	if len(upToDate) != 1 {
+		t.Errorf("IXFR of up to date zone = %v, want single SOA", upToDate)
+	}
+}
+
+func TestSerialContinues(t *testing.T) {
+	old := newTestMap(conflictMerge)
+	old.serial += 1000
+	old.addContainer(&ContainerData{id: "web", hosts: []string{"web.loc."}, ipv4: []net.IP{parseIP("172.28.0.2")}})
+	// map of reloaded Corefile starts after the latest serial
+	if reloaded := newTestMap(conflictMerge); !serialLess(old.currentSerial(), reloaded.currentSerial()) {
+		t.Errorf("serial after reload = %d, want after %d", reloaded.currentSerial(), old.currentSerial())
+	}
+
+	saved := old.currentSerial() + 1000
+	m := newTestMap(conflictMerge)
+	m.advanceSerial(saved)
+	if !serialLess(saved, m.currentSerial()) {
+		t.Errorf("serial after snapshot = %d, want after %d", m.currentSerial(), saved)
+	}
+	// serial ahead of the saved one is kept
+	current := m.currentSerial()
+	m.advanceSerial(saved - 10)
+	if m.currentSerial() != current {
+		t.Errorf("serial = %d, want %d kept", m.currentSerial(), current)
+	}
+}
+
+func TestTransferMatchesAnswers(t *testing.T) {
+	dd := setupTransferDD(t)
+	dd.connected = 1
+	dd.hmap.addContainer(&ContainerData{id: "web", hosts: []string{"web.loc."}, ipv4: []net.IP{parseIP("172.28.0.2")}})
+
+	for _, zone := range []string{"loc.", "28.172.in-addr.arpa."} {
+		for _, rr := range readTransfer(t, dd, zone, 0) {
+			r := new(dns.Msg)
+			r.SetQuestion(rr.Header().Name, rr.Header().Rrtype)
+			rec := dnstest.NewRecorder(&test.ResponseWriter{})
+			if _, err := dd.ServeDNS(context.Background(), rec, r); err != nil {
+				t.Fatalf("ServeDNS() error = %v", err)
+			}
+			found := false
+			for _, answer := range rec.Msg.Answer {
+				found = found || dns.IsDuplicate(answer, rr)
+			}
+			if !found {
+				t.Errorf("transferred record %s is not answered: %v", rr, rec.Msg)
+			}
+		}
+	}
+	// the parent of the nameserver is an empty non-terminal
+	r := new(dns.Msg)
+	r.SetQuestion("dns.loc.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	dd.ServeDNS(context.Background(), rec, r)
+	if rec.Msg.Rcode != dns.RcodeSuccess || len(rec.Msg.Answer) != 0 {
+		t.Errorf("dns.loc. A = %v, want NODATA", rec.Msg)
+	}
+}
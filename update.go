@@ -0,0 +1,477 @@
+package dockerdns
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin"
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultUpdateBatch   = time.Second
+	defaultUpdateRetries = 3
+	defaultUpdateBackoff = time.Second
+	updateTsigFudge      = 300
+
+	// updateOwnerMarker is the TXT record added to every name the plugin
+	// pushes, sync deletes records only at names having it
+	updateOwnerMarker = "heritage=coredns-dockerdns"
+)
+
+// updateSink pushes record changes to a DNS server with RFC 2136 UPDATE messages.
+// Changes are collected for batch interval and sent per zone. Changes of failed
+// updates are merged with later ones and retried after backoff.
+type updateSink struct {
+	addr       string
+	zones      []string
+	tsigName   string
+	tsigAlg    string
+	tsigSecret string
+	batch      time.Duration
+	retries    int
+	backoff    time.Duration // delay of the first retry, it grows with every failure
+
+	client *dns.Client
+
+	// flushMu keeps order of sent updates
+	flushMu  sync.Mutex
+	mu       sync.Mutex
+	removed  []dns.RR
+	added    []dns.RR
+	timer    *time.Timer
+	failures int // consecutive failed flushes
+	stopped  bool
+
+	// pushed are records accepted by the server by name and rrKey,
+	// it is changed only while flushMu is held
+	pushed map[string]map[string]dns.RR
+}
+
+func newUpdateSink(addr string) *updateSink {
+	return &updateSink{
+		addr:    addr,
+		tsigAlg: dns.HmacSHA256,
+		batch:   defaultUpdateBatch,
+		retries: defaultUpdateRetries,
+		backoff: defaultUpdateBackoff,
+		client:  &dns.Client{Net: "tcp", Timeout: 5 * time.Second},
+		pushed:  map[string]map[string]dns.RR{},
+	}
+}
+
+// parseUpdateServer parses update_server directive:
+//
+//	update_server ADDR {
+//	    zone ZONES...
+//	    tsig NAME ALGORITHM SECRET
+//	    batch DURATION
+//	    retries COUNT
+//	}
+func parseUpdateServer(c *caddy.Controller) (*updateSink, error) {
+	args := c.RemainingArgs()
+	if len(args) != 1 {
+		return nil, c.ArgErr()
+	}
+	addr, err := normalizeUpdateAddr(args[0])
+	if err != nil {
+		return nil, c.Errf("update_server: %s", err)
+	}
+	u := newUpdateSink(addr)
+	// RemainingArgs stops before opening brace of a block
+	if !c.NextArg() {
+		return u, nil
+	}
+	err = parseSubBlock(c, func(key string, args []string) error {
+		switch key {
+		case "zone":
+			if len(args) == 0 {
+				return c.ArgErr()
+			}
+			for _, z := range args {
+				u.zones = append(u.zones, plugin.Name(z).Normalize())
+			}
+		case "tsig":
+			if len(args) != 3 {
+				return c.ArgErr()
+			}
+			u.tsigName = dns.CanonicalName(args[0])
+			u.tsigAlg = dns.CanonicalName(args[1])
+			u.tsigSecret = args[2]
+			u.client.TsigSecret = map[string]string{u.tsigName: u.tsigSecret}
+		case "batch":
+			if len(args) != 1 {
+				return c.ArgErr()
+			}
+			d, err := time.ParseDuration(args[0])
+			if err != nil || d < 0 {
+				return c.Errf("invalid batch duration: %s", args[0])
+			}
+			u.batch = d
+		case "retries":
+			if len(args) != 1 {
+				return c.ArgErr()
+			}
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 0 {
+				return c.Errf("invalid retries count: %s", args[0])
+			}
+			u.retries = n
+		default:
+			return c.Errf("unknown update_server property '%s'", key)
+		}
+		return nil
+	})
+	return u, err
+}
+
+// parseSubBlock calls f for every line of block opened on the current line.
+func parseSubBlock(c *caddy.Controller, f func(key string, args []string) error) error {
+	for c.Next() {
+		if c.Val() == "}" {
+			return nil
+		}
+		if err := f(c.Val(), c.RemainingArgs()); err != nil {
+			return err
+		}
+	}
+	return c.EOFErr()
+}
+
+func normalizeUpdateAddr(addr string) (string, error) {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr, nil
+	}
+	if parseIP(addr) == nil {
+		return "", fmt.Errorf("invalid address: %s", addr)
+	}
+	return net.JoinHostPort(addr, "53"), nil
+}
+
+// enqueue schedules journal changes, it is called while Map is locked.
+func (u *updateSink) enqueue(e *journalEntry) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.stopped {
+		return
+	}
+	u.removed = append(u.removed, e.removed...)
+	u.added = append(u.added, e.added...)
+	if u.timer == nil {
+		u.timer = time.AfterFunc(u.batch, u.flush)
+	}
+}
+
+// sync replaces records of update zones at the server with current records.
+// Records the plugin pushed earlier missing in current ones, i.e. of containers
+// removed while coredns was down, are deleted. They are found with AXFR of the
+// zone at names having ownership marker, records of other names are never
+// touched. If the server refuses the transfer, only records pushed by this
+// instance are deleted.
+func (u *updateSink) sync(records []dns.RR) {
+	current, names := map[string]bool{}, map[string]bool{}
+	for _, rr := range records {
+		current[rrKey(rr)] = true
+		names[rr.Header().Name] = true
+	}
+	var removed []dns.RR
+	u.flushMu.Lock()
+	for _, zone := range u.zones {
+		upstream, err := u.transfer(zone)
+		if err != nil {
+			log.Warningf("[docker] transfer zone %s from %s, records of removed containers may stay: %s", zone, u.addr, err)
+			continue
+		}
+		upstream = zoneRRs(zone, u.zones, upstream)
+		for _, rr := range ownedRRs(upstream) {
+			u.remember(rr)
+		}
+		// markers left without records
+		for _, rr := range upstream {
+			if name := rr.Header().Name; isOwnerMarker(rr) && u.pushed[name] == nil && !names[name] {
+				removed = append(removed, rr)
+			}
+		}
+	}
+	for _, rrs := range u.pushed {
+		for key, rr := range rrs {
+			if !current[key] {
+				removed = append(removed, rr)
+			}
+		}
+	}
+	u.flushMu.Unlock()
+
+	u.mu.Lock()
+	u.removed = append(u.removed, removed...)
+	u.added = append(u.added, records...)
+	u.mu.Unlock()
+	u.flush()
+}
+
+// transfer returns records of zone at the server.
+func (u *updateSink) transfer(zone string) ([]dns.RR, error) {
+	m := new(dns.Msg)
+	m.SetAxfr(zone)
+	if u.tsigName != "" {
+		m.SetTsig(u.tsigName, u.tsigAlg, updateTsigFudge, time.Now().Unix())
+	}
+	t := &dns.Transfer{
+		DialTimeout: u.client.Timeout,
+		ReadTimeout: u.client.Timeout,
+		TsigSecret:  u.client.TsigSecret,
+	}
+	ch, err := t.In(m, u.addr)
+	if err != nil {
+		return nil, err
+	}
+	var rrs []dns.RR
+	for env := range ch {
+		if env.Error != nil {
+			return nil, env.Error
+		}
+		rrs = append(rrs, env.RR...)
+	}
+	return rrs, nil
+}
+
+// zoneRRs returns records belonging to zone of zones.
+func zoneRRs(zone string, zones []string, records []dns.RR) []dns.RR {
+	var res []dns.RR
+	for _, rr := range records {
+		if plugin.Zones(zones).Matches(rr.Header().Name) == zone {
+			res = append(res, rr)
+		}
+	}
+	return res
+}
+
+// ownedRRs returns upstream records at names having ownership marker,
+// except the marker itself and zone apex records.
+func ownedRRs(upstream []dns.RR) []dns.RR {
+	owned := map[string]bool{}
+	for _, rr := range upstream {
+		if isOwnerMarker(rr) {
+			owned[rr.Header().Name] = true
+		}
+	}
+	var res []dns.RR
+	for _, rr := range upstream {
+		switch rr.Header().Rrtype {
+		case dns.TypeSOA, dns.TypeNS:
+			continue
+		}
+		if owned[rr.Header().Name] && !isOwnerMarker(rr) {
+			res = append(res, rr)
+		}
+	}
+	return res
+}
+
+// ownerMarker returns ownership marker of name.
+func ownerMarker(name string, ttl uint32) dns.RR {
+	return &dns.TXT{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: ttl},
+		Txt: []string{updateOwnerMarker},
+	}
+}
+
+func isOwnerMarker(rr dns.RR) bool {
+	txt, ok := rr.(*dns.TXT)
+	return ok && len(txt.Txt) == 1 && txt.Txt[0] == updateOwnerMarker
+}
+
+// rrKey identifies record regardless of its ttl.
+func rrKey(rr dns.RR) string {
+	c := dns.Copy(rr)
+	c.Header().Ttl = 0
+	return c.String()
+}
+
+// remember adds rr to records accepted by the server.
+func (u *updateSink) remember(rr dns.RR) {
+	name := rr.Header().Name
+	if u.pushed[name] == nil {
+		u.pushed[name] = map[string]dns.RR{}
+	}
+	u.pushed[name][rrKey(rr)] = rr
+}
+
+// forget removes rr from records accepted by the server.
+func (u *updateSink) forget(rr dns.RR) {
+	name := rr.Header().Name
+	delete(u.pushed[name], rrKey(rr))
+	if len(u.pushed[name]) == 0 {
+		delete(u.pushed, name)
+	}
+}
+
+// markers returns changes of ownership markers of names changed by z,
+// names get the marker with the first pushed record and lose it with the last one.
+func (u *updateSink) markers(z *zoneUpdate) (removed, added []dns.RR) {
+	after := map[string]map[string]bool{}
+	var names []string
+	touch := func(rr dns.RR) map[string]bool {
+		name := rr.Header().Name
+		keys, ok := after[name]
+		if !ok {
+			keys = map[string]bool{}
+			for key := range u.pushed[name] {
+				keys[key] = true
+			}
+			after[name] = keys
+			names = append(names, name)
+		}
+		return keys
+	}
+	ttls := map[string]uint32{}
+	for _, rr := range z.removed {
+		delete(touch(rr), rrKey(rr))
+	}
+	for _, rr := range z.added {
+		touch(rr)[rrKey(rr)] = true
+		ttls[rr.Header().Name] = rr.Header().Ttl
+	}
+	for _, name := range names {
+		switch {
+		case len(after[name]) == 0 && len(u.pushed[name]) != 0:
+			removed = append(removed, ownerMarker(name, 0))
+		case len(after[name]) != 0 && len(u.pushed[name]) == 0:
+			added = append(added, ownerMarker(name, ttls[name]))
+		}
+	}
+	return removed, added
+}
+
+// stop sends pending changes and disables the sink.
+func (u *updateSink) stop() {
+	u.flush()
+	u.mu.Lock()
+	u.stopped = true
+	if u.timer != nil {
+		u.timer.Stop()
+		u.timer = nil
+	}
+	u.mu.Unlock()
+}
+
+func (u *updateSink) flush() {
+	u.flushMu.Lock()
+	defer u.flushMu.Unlock()
+	u.mu.Lock()
+	removed, added := diffRRs(u.removed, u.added)
+	u.removed, u.added = nil, nil
+	if u.timer != nil {
+		u.timer.Stop()
+		u.timer = nil
+	}
+	u.mu.Unlock()
+
+	var failedRemoved, failedAdded []dns.RR
+	for _, z := range u.zoneChanges(removed, added) {
+		markersRemoved, markersAdded := u.markers(z)
+		if err := u.send(z.msg(markersRemoved, markersAdded)); err != nil {
+			log.Errorf("[docker] update zone %s at %s: %s", z.zone, u.addr, err)
+			failedRemoved = append(failedRemoved, z.removed...)
+			failedAdded = append(failedAdded, z.added...)
+			continue
+		}
+		for _, rr := range z.removed {
+			u.forget(rr)
+		}
+		for _, rr := range z.added {
+			u.remember(rr)
+		}
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if len(failedRemoved) == 0 && len(failedAdded) == 0 {
+		u.failures = 0
+		return
+	}
+	if u.stopped || u.failures >= u.retries {
+		log.Errorf("[docker] drop %d changes not accepted by %s after %d retries",
+			len(failedRemoved)+len(failedAdded), u.addr, u.failures)
+		u.failures = 0
+		return
+	}
+	// failed changes precede changes queued meanwhile,
+	// retry is scheduled without holding flushMu
+	u.failures++
+	u.removed = append(failedRemoved, u.removed...)
+	u.added = append(failedAdded, u.added...)
+	if u.timer != nil {
+		u.timer.Stop()
+	}
+	u.timer = time.AfterFunc(time.Duration(u.failures)*u.backoff, u.flush)
+}
+
+// zoneUpdate is a set of changes of one zone sent in one UPDATE message.
+type zoneUpdate struct {
+	zone    string
+	removed []dns.RR
+	added   []dns.RR
+}
+
+// msg returns UPDATE message of changes with changes of ownership markers,
+// records are deleted one by one, so records of other sources are kept.
+func (z *zoneUpdate) msg(markersRemoved, markersAdded []dns.RR) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetUpdate(z.zone)
+	for _, rr := range append(append([]dns.RR{}, z.removed...), markersRemoved...) {
+		m.Remove([]dns.RR{dns.Copy(rr)})
+	}
+	for _, rr := range append(append([]dns.RR{}, z.added...), markersAdded...) {
+		m.Insert([]dns.RR{dns.Copy(rr)})
+	}
+	return m
+}
+
+// zoneChanges groups changes by zone.
+func (u *updateSink) zoneChanges(removed, added []dns.RR) []*zoneUpdate {
+	updates := map[string]*zoneUpdate{}
+	order := []*zoneUpdate{}
+	get := func(rr dns.RR) *zoneUpdate {
+		zone := plugin.Zones(u.zones).Matches(rr.Header().Name)
+		if zone == "" {
+			return nil
+		}
+		z, ok := updates[zone]
+		if !ok {
+			z = &zoneUpdate{zone: zone}
+			updates[zone] = z
+			order = append(order, z)
+		}
+		return z
+	}
+	for _, rr := range removed {
+		if z := get(rr); z != nil {
+			z.removed = append(z.removed, rr)
+		}
+	}
+	for _, rr := range added {
+		if z := get(rr); z != nil {
+			z.added = append(z.added, rr)
+		}
+	}
+	return order
+}
+
+func (u *updateSink) send(m *dns.Msg) error {
+	if u.tsigName != "" {
+		m.SetTsig(u.tsigName, u.tsigAlg, updateTsigFudge, time.Now().Unix())
+	}
+	r, _, err := u.client.Exchange(m, u.addr)
+	if err != nil {
+		return err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("rcode %s", dns.RcodeToString[r.Rcode])
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package dockerdns
+
+import (
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+const testTsigSecret = "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0"
+
+// startUpdateServer runs a local server which records received UPDATE messages.
+// It answers AXFR with zone records if there are any, and fails the first
+// failures updates.
+func startUpdateServer(t *testing.T, zone []dns.RR, failures int) (string, func() []*dns.Msg) {
+	var (
+		mu   sync.Mutex
+		msgs []*dns.Msg
+	)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{
+		Listener:      l,
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+		TsigSecret:    map[string]string{"update.": testTsigSecret},
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case r.IsTsig() == nil || w.TsigStatus() != nil:
+				m.Rcode = dns.RcodeRefused
+			case r.Opcode == dns.OpcodeQuery && r.Question[0].Qtype == dns.TypeAXFR:
+				if zone == nil {
+					m.Rcode = dns.RcodeRefused
+				}
+				m.Answer = zone
+			case failures > 0:
+				failures--
+				m.Rcode = dns.RcodeServerFailure
+			default:
+				msgs = append(msgs, r)
+			}
+			w.WriteMsg(m)
+		}),
+	}
+	go srv.ActivateAndServe()
+	t.Cleanup(func() { srv.Shutdown() })
+	return l.Addr().String(), func() []*dns.Msg {
+		mu.Lock()
+		defer mu.Unlock()
+		return msgs
+	}
+}
+
+func TestParseUpdateServer(t *testing.T) {
+	c := caddy.NewTestController("dns", `update_server 10.0.0.53 {
+		zone loc
+		tsig update. hmac-sha256 `+testTsigSecret+`
+		batch 100ms
+		retries 1
+	}`)
+	c.Next()
+	u, err := parseUpdateServer(c)
+	if err != nil {
+		t.Fatalf("parseUpdateServer() error = %v", err)
+	}
+	if u.addr != "10.0.0.53:53" || len(u.zones) != 1 || u.zones[0] != "loc." ||
+		u.tsigName != "update." || u.batch != 100*time.Millisecond || u.retries != 1 {
+		t.Errorf("parseUpdateServer() = %+v", u)
+	}
+	if c.Next() {
+		t.Errorf("parseUpdateServer() left unparsed token %s", c.Val())
+	}
+}
+
+func TestUpdateSink(t *testing.T) {
+	addr, received := startUpdateServer(t, nil, 0)
+	u := newUpdateSink(addr)
+	u.zones = []string{"loc."}
+	u.retries = 0
+	u.batch = 10 * time.Millisecond
+	u.tsigName = "update."
+	u.client.TsigSecret = map[string]string{u.tsigName: testTsigSecret}
+
+	m := newTestMap(conflictMerge)
+	m.addContainer(&ContainerData{id: "web", hosts: []string{"web.loc."}, ipv4: []net.IP{parseIP("172.28.0.2")}})
+	_, records := m.records()
+	u.sync(records)
+
+	m.subscribe(u.enqueue)
+	m.removeContainer("web")
+	m.addContainer(&ContainerData{id: "db", hosts: []string{"db.loc."}, ipv4: []net.IP{parseIP("172.28.0.3")}})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(received()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	msgs := received()
+	if len(msgs) != 2 {
+		t.Fatalf("received %d updates, want 2", len(msgs))
+	}
+	// transfer is refused, so nothing is deleted, web.loc. gets ownership marker
+	want := []string{
+		"web.loc.\t30\tIN\tA\t172.28.0.2",
+		"web.loc.\t30\tIN\tTXT\t\"" + updateOwnerMarker + "\"",
+	}
+	if got := rrStrings(msgs[0].Ns); !reflect.DeepEqual(got, want) {
+		t.Errorf("initial sync = %v, want %v", got, want)
+	}
+	// batch removes web.loc. with its marker and adds db.loc.
+	want = []string{
+		"web.loc.\t0\tNONE\tA\t172.28.0.2",
+		"web.loc.\t0\tNONE\tTXT\t\"" + updateOwnerMarker + "\"",
+		"db.loc.\t30\tIN\tA\t172.28.0.3",
+		"db.loc.\t30\tIN\tTXT\t\"" + updateOwnerMarker + "\"",
+	}
+	if got := rrStrings(msgs[1].Ns); !reflect.DeepEqual(got, want) {
+		t.Errorf("batched update = %v, want %v", got, want)
+	}
+}
+
+func rrStrings(rrs []dns.RR) []string {
+	var res []string
+	for _, rr := range rrs {
+		res = append(res, rr.String())
+	}
+	return res
+}
+
+func TestUpdateSinkSync(t *testing.T) {
+	zone := []dns.RR{
+		test.SOA("loc. 300 IN SOA ns.loc. hostmaster.loc. 1 7200 1800 86400 30"),
+		test.NS("loc. 300 IN NS ns.loc."),
+		test.A("web.loc. 30 IN A 172.28.0.2"),
+		test.TXT(`web.loc. 30 IN TXT "` + updateOwnerMarker + `"`),
+		// container removed while coredns was down
+		test.A("old.loc. 30 IN A 172.28.0.9"),
+		test.TXT(`old.loc. 30 IN TXT "` + updateOwnerMarker + `"`),
+		// records maintained by other means
+		test.MX("loc. 300 IN MX 10 mail.loc."),
+		test.A("mail.loc. 300 IN A 10.0.0.25"),
+		test.SOA("loc. 300 IN SOA ns.loc. hostmaster.loc. 1 7200 1800 86400 30"),
+	}
+	addr, received := startUpdateServer(t, zone, 0)
+	u := newUpdateSink(addr)
+	u.zones = []string{"loc."}
+	u.tsigName = "update."
+	u.client.TsigSecret = map[string]string{u.tsigName: testTsigSecret}
+
+	m := newTestMap(conflictMerge)
+	m.addContainer(&ContainerData{id: "web", hosts: []string{"web.loc."}, ipv4: []net.IP{parseIP("172.28.0.2")}})
+	_, records := m.records()
+	u.sync(records)
+
+	msgs := received()
+	if len(msgs) != 1 {
+		t.Fatalf("received %d updates, want 1", len(msgs))
+	}
+	// only records at marked names not served anymore are deleted
+	want := []string{
+		"old.loc.\t0\tNONE\tA\t172.28.0.9",
+		"old.loc.\t0\tNONE\tTXT\t\"" + updateOwnerMarker + "\"",
+		"web.loc.\t30\tIN\tA\t172.28.0.2",
+	}
+	if got := rrStrings(msgs[0].Ns); !reflect.DeepEqual(got, want) {
+		t.Errorf("initial sync = %v, want %v", got, want)
+	}
+}
+
+func TestUpdateSinkRetry(t *testing.T) {
+	addr, received := startUpdateServer(t, nil, 1)
+	u := newUpdateSink(addr)
+	u.zones = []string{"loc."}
+	u.backoff = 50 * time.Millisecond
+	u.batch = time.Hour
+	u.tsigName = "update."
+	u.client.TsigSecret = map[string]string{u.tsigName: testTsigSecret}
+
+	m := newTestMap(conflictMerge)
+	m.subscribe(u.enqueue)
+	m.addContainer(&ContainerData{id: "web", hosts: []string{"web.loc."}, ipv4: []net.IP{parseIP("172.28.0.2")}})
+	u.flush()
+	// failed update is retried later, so the next flush isn't blocked
+	if !u.flushMu.TryLock() {
+		t.Fatalf("flush lock is held during backoff")
+	}
+	u.flushMu.Unlock()
+	m.addContainer(&ContainerData{id: "db", hosts: []string{"db.loc."}, ipv4: []net.IP{parseIP("172.28.0.3")}})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(received()) < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	msgs := received()
+	if len(msgs) != 1 {
+		t.Fatalf("received %d updates, want 1", len(msgs))
+	}
+	// retry carries failed change and the one queued meanwhile
+	if len(msgs[0].Ns) != 4 || msgs[0].Ns[0].Header().Name != "web.loc." || msgs[0].Ns[1].Header().Name != "db.loc." {
+		t.Errorf("retried update = %v, want web.loc. and db.loc. added", msgs[0].Ns)
+	}
+}
@@ -0,0 +1,345 @@
+package dockerdns
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin"
+)
+
+const (
+	viewAddressesContainer = "container"
+	viewAddressesHost      = "host"
+)
+
+// view changes answers for clients from listed networks. All views share
+// the same container index, they only select which containers and which
+// of their addresses are visible.
+type view struct {
+	name    string
+	clients []*net.IPNet
+	zones   []string // origins answered in view, all origins when empty
+
+	// addresses is container or host, host view resolves containers to
+	// addresses their ports are bound to, wildcard bindings are replaced
+	// with ipv4 and ipv6 or with external addresses of publish_mode
+	addresses string
+	ipv4      []net.IP
+	ipv6      []net.IP
+
+	include []*containerFilter
+	exclude []*containerFilter
+
+	// picks are addresses selected while answering one request, see forRequest
+	picks map[string][]net.IP
+}
+
+// parseView parses view directive:
+//
+//	view NAME {
+//	    clients CIDR...
+//	    zones ZONES...
+//	    addresses container|host [IP...]
+//	    include FILTERS...
+//	    exclude FILTERS...
+//	}
+func (dd *DockerDiscovery) parseView(c *caddy.Controller) (*view, error) {
+	args := c.RemainingArgs()
+	if len(args) != 1 {
+		return nil, c.ArgErr()
+	}
+	v := &view{name: args[0], addresses: viewAddressesContainer}
+	// RemainingArgs stops before opening brace of a block
+	if !c.NextArg() {
+		return nil, c.Errf("view %s: block is required", v.name)
+	}
+	err := parseSubBlock(c, func(key string, args []string) error {
+		switch key {
+		case "clients":
+			if len(args) == 0 {
+				return c.ArgErr()
+			}
+			for _, arg := range args {
+				_, ipnet, err := net.ParseCIDR(arg)
+				if err != nil {
+					if ip := parseIP(arg); ip != nil {
+						ipnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}
+					} else {
+						return c.Errf("view %s: invalid client network: %s", v.name, arg)
+					}
+				}
+				v.clients = append(v.clients, ipnet)
+			}
+		case "zones":
+			if len(args) == 0 {
+				return c.ArgErr()
+			}
+			for _, z := range args {
+				zone := plugin.Name(z).Normalize()
+				if !dd.isOrigin(zone) {
+					return c.Errf("view %s: zone %s is not in Origins", v.name, zone)
+				}
+				v.zones = append(v.zones, zone)
+			}
+		case "addresses":
+			if len(args) == 0 {
+				return c.ArgErr()
+			}
+			switch args[0] {
+			case viewAddressesContainer:
+				if len(args) != 1 {
+					return c.ArgErr()
+				}
+			case viewAddressesHost:
+				var err error
+				if v.ipv4, v.ipv6, err = splitIPs(args[1:]); err != nil {
+					return c.Errf("view %s: %s", v.name, err)
+				}
+			default:
+				return c.Errf("view %s: addresses must be %s or %s: %s",
+					v.name, viewAddressesContainer, viewAddressesHost, args[0])
+			}
+			v.addresses = args[0]
+		case "include", "exclude":
+			if len(args) == 0 {
+				return c.ArgErr()
+			}
+			for _, arg := range args {
+				f, err := newContainerFilter(arg)
+				if err != nil {
+					return c.Errf("view %s: %s", v.name, err)
+				}
+				if key == "include" {
+					v.include = append(v.include, f)
+				} else {
+					v.exclude = append(v.exclude, f)
+				}
+			}
+		default:
+			return c.Errf("view %s: unknown property '%s'", v.name, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(v.clients) == 0 {
+		return nil, c.Errf("view %s: clients are required", v.name)
+	}
+	return v, nil
+}
+
+func (v *view) String() string {
+	return fmt.Sprintf("%s clients %v zones %v addresses %s", v.name, v.clients, v.zones, v.addresses)
+}
+
+// match reports whether query of client ip is answered in view.
+func (v *view) match(ip net.IP) bool {
+	for _, n := range v.clients {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasZone reports whether zone is answered in view.
+func (v *view) hasZone(zone string) bool {
+	return v == nil || len(v.zones) == 0 || indexOf(v.zones, zone) >= 0
+}
+
+// custom reports whether view changes answers of container index.
+func (v *view) custom() bool {
+	return v != nil && (v.addresses != viewAddressesContainer || len(v.include) != 0 || len(v.exclude) != 0)
+}
+
+// visible reports whether container is visible in view by its filters,
+// all containers are visible without view.
+func (v *view) visible(c *ContainerData) bool {
+	if v == nil {
+		return true
+	}
+	for _, f := range v.exclude {
+		if f.MatchData(c) {
+			return false
+		}
+	}
+	if len(v.include) == 0 {
+		return true
+	}
+	for _, f := range v.include {
+		if f.MatchData(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// findView returns the first view of client address, nil if there is none.
+func (dd *DockerDiscovery) findView(addr string) *view {
+	ip := parseIP(addr)
+	if ip == nil {
+		return nil
+	}
+	for _, v := range dd.opts.views {
+		if v.match(ip) {
+			return v
+		}
+	}
+	return nil
+}
+
+// forRequest returns copy of view, or of the default view for nil, which
+// remembers addresses selected while answering one request. So A, AAAA,
+// HTTPS hints and glue of an answer agree on chosen weighted containers.
+func (v *view) forRequest() *view {
+	rv := &view{addresses: viewAddressesContainer}
+	if v != nil {
+		*rv = *v
+	}
+	rv.picks = map[string][]net.IP{}
+	return rv
+}
+
+// containerIPs returns addresses of container answered in view. Host view
+// answers only addresses its published ports are bound to, so containers
+// without port bindings have none.
+func (dd *DockerDiscovery) containerIPs(v *view, c *ContainerData) ([]net.IP, []net.IP) {
+	if v == nil || v.addresses != viewAddressesHost {
+		return c.ipv4, c.ipv6
+	}
+	ext4, ext6 := v.ipv4, v.ipv6
+	if len(ext4) == 0 && len(ext6) == 0 {
+		ext4, ext6 = dd.externalAddresses()
+	}
+	return bindingAddresses(c.bindings, ext4, ext6)
+}
+
+// viewIPs returns addresses of host visible in view, publishers are
+// selected by priority and weight labels as without view.
+func (dd *DockerDiscovery) viewIPs(v *view, host string, ipv6 bool) []net.IP {
+	var visible []*ContainerData
+	for _, c := range dd.hmap.publishers(host) {
+		if v.visible(c) {
+			visible = append(visible, c)
+		}
+	}
+	family := func(c *ContainerData) []net.IP {
+		ipv4, ipv6s := dd.containerIPs(v, c)
+		if ipv6 {
+			return ipv6s
+		}
+		return ipv4
+	}
+	var res []net.IP
+	for _, c := range choosePublishers(visible, family, dd.intn) {
+		res = appendIPs(res, family(c))
+	}
+	return res
+}
+
+// viewPTR returns names of address visible in view. Host view answers
+// names of containers having ports bound to the address instead of names
+// of container addresses.
+func (dd *DockerDiscovery) viewPTR(v *view, addr string) []string {
+	if v.addresses == viewAddressesHost {
+		ip := parseIP(addr)
+		if ip == nil || !dd.opts.autoReverse {
+			return nil
+		}
+		var ids []string
+		dd.hmap.ids.Range(func(id string, c *ContainerData) bool {
+			ipv4, ipv6 := dd.containerIPs(v, c)
+			if v.visible(c) && (containsIP(ipv4, ip) || containsIP(ipv6, ip)) {
+				ids = append(ids, id)
+			}
+			return false
+		})
+		sort.Strings(ids)
+		var res []string
+		for _, id := range ids {
+			c, _ := dd.hmap.ids.Load(id)
+			for _, h := range c.hosts {
+				if indexOf(res, h) < 0 {
+					res = append(res, h)
+				}
+			}
+		}
+		return res
+	}
+	names, _ := dd.hmap.addr.Load(addr)
+	owners, _ := dd.hmap.addrOwners.Load(addr)
+	visible := map[string]struct{}{}
+	for _, id := range owners {
+		c, ok := dd.hmap.ids.Load(id)
+		if !ok || !v.visible(c) {
+			continue
+		}
+		for _, h := range c.hosts {
+			visible[h] = struct{}{}
+		}
+	}
+	var res []string
+	for _, n := range names {
+		if _, ok := visible[n]; ok {
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
+// visibleID reports whether container with id is visible in view.
+func (dd *DockerDiscovery) visibleID(v *view) func(id string) bool {
+	return func(id string) bool {
+		if v == nil {
+			return true
+		}
+		c, ok := dd.hmap.ids.Load(id)
+		return ok && v.visible(c)
+	}
+}
+
+// addresses returns ipv4 or ipv6 addresses of host answered in view,
+// the selection is made once per request.
+func (dd *DockerDiscovery) addresses(v *view, host string, ipv6 bool) []net.IP {
+	key := host + "/A"
+	if ipv6 {
+		key = host + "/AAAA"
+	}
+	if v != nil {
+		if ips, ok := v.picks[key]; ok {
+			return ips
+		}
+	}
+	ips := dd.selectAddresses(v, host, ipv6)
+	if v != nil && v.picks != nil {
+		v.picks[key] = ips
+	}
+	return ips
+}
+
+func (dd *DockerDiscovery) selectAddresses(v *view, host string, ipv6 bool) []net.IP {
+	if v.custom() {
+		return dd.viewIPs(v, host, ipv6)
+	}
+	if ips, ok := dd.hmap.selectIPs(host, ipv6, dd.intn); ok {
+		return ips
+	}
+	if ipv6 {
+		ips, _ := dd.hmap.name6.Load(host)
+		return ips
+	}
+	ips, _ := dd.hmap.name4.Load(host)
+	return ips
+}
+
+// ptrNames returns names of address answered in view.
+func (dd *DockerDiscovery) ptrNames(v *view, addr string) []string {
+	if v.custom() {
+		return dd.viewPTR(v, addr)
+	}
+	names, _ := dd.hmap.addr.Load(addr)
+	return names
+}
@@ -0,0 +1,149 @@
+package dockerdns
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+func TestParseView(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "host addresses",
+			input: `view lan {
+				clients 192.168.0.0/16 10.0.0.1
+				zones loc
+				addresses host 192.168.1.10
+				exclude name=db
+			}`,
+			want: "lan clients [192.168.0.0/16 10.0.0.1/32] zones [loc.] addresses host",
+		},
+		{name: "container addresses", input: `view docker {
+				clients 172.16.0.0/12
+			}`, want: "docker clients [172.16.0.0/12] zones [] addresses container"},
+		{name: "no block", input: `view lan`, wantErr: true},
+		{name: "no clients", input: `view lan {
+				addresses host
+			}`, wantErr: true},
+		{name: "bad client", input: `view lan {
+				clients lan
+			}`, wantErr: true},
+		{name: "unknown zone", input: `view lan {
+				clients 10.0.0.0/8
+				zones other
+			}`, wantErr: true},
+		{name: "bad addresses", input: `view lan {
+				clients 10.0.0.0/8
+				addresses bridge
+			}`, wantErr: true},
+		{name: "bad filter", input: `view lan {
+				clients 10.0.0.0/8
+				include db
+			}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dd := NewDockerDiscovery("")
+			dd.Origins = []string{"loc."}
+			c := caddy.NewTestController("dns", tt.input)
+			c.Next()
+			v, err := dd.parseView(c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseView() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && v.String() != tt.want {
+				t.Errorf("parseView() = %s, want %s", v, tt.want)
+			}
+		})
+	}
+}
+
+func TestViews(t *testing.T) {
+	dd := NewDockerDiscovery("")
+	dd.Origins = []string{"loc."}
+	dd.connected = 1
+	dd.opts.autoReverse = true
+	lan, err := newContainerFilter("name=db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dd.opts.views = []*view{{
+		name:      "lan",
+		clients:   []*net.IPNet{{IP: net.IPv4(192, 168, 0, 0), Mask: net.CIDRMask(16, 32)}},
+		addresses: viewAddressesHost,
+		ipv4:      []net.IP{parseIP("192.168.1.10")},
+		exclude:   []*containerFilter{lan},
+	}}
+	dd.hmap.addContainer(&ContainerData{id: "web", name: "web", hosts: []string{"web.loc."},
+		ipv4: []net.IP{parseIP("172.28.0.2")}, bindings: []string{""}})
+	dd.hmap.addContainer(&ContainerData{id: "db", name: "db", hosts: []string{"db.loc."},
+		ipv4: []net.IP{parseIP("172.28.0.3")}})
+	dd.hmap.addContainer(&ContainerData{id: "cache", name: "cache", hosts: []string{"cache.loc."},
+		ipv4: []net.IP{parseIP("172.28.0.4")}})
+	weight := uint32(1)
+	dd.hmap.addContainer(&ContainerData{id: "api-1", name: "api-1", hosts: []string{"api.loc."},
+		ipv4: []net.IP{parseIP("172.28.0.5")}, bindings: []string{"192.168.1.11"}, weight: &weight})
+	dd.hmap.addContainer(&ContainerData{id: "api-2", name: "api-2", hosts: []string{"api.loc."},
+		ipv4: []net.IP{parseIP("172.28.0.6")}, bindings: []string{"192.168.1.12"}, weight: &weight})
+	dd.intn = func(int) int { return 0 }
+
+	tests := []struct {
+		name      string
+		client    string
+		qname     string
+		qtype     uint16
+		want      []string
+		wantRcode int
+	}{
+		{name: "docker web", qname: "web.loc.", qtype: dns.TypeA, want: []string{"172.28.0.2"}},
+		{name: "docker db", qname: "db.loc.", qtype: dns.TypeA, want: []string{"172.28.0.3"}},
+		{name: "docker ptr", qname: "3.0.28.172.in-addr.arpa.", qtype: dns.TypePTR, want: []string{"db.loc."}},
+		{name: "lan web", client: "192.168.1.20", qname: "web.loc.", qtype: dns.TypeA, want: []string{"192.168.1.10"}},
+		{name: "lan db", client: "192.168.1.20", qname: "db.loc.", qtype: dns.TypeA, wantRcode: dns.RcodeNameError},
+		{name: "lan ptr", client: "192.168.1.20", qname: "3.0.28.172.in-addr.arpa.", qtype: dns.TypePTR, wantRcode: dns.RcodeServerFailure},
+		{name: "lan container ptr", client: "192.168.1.20", qname: "2.0.28.172.in-addr.arpa.", qtype: dns.TypePTR, wantRcode: dns.RcodeServerFailure},
+		{name: "lan host ptr", client: "192.168.1.20", qname: "10.1.168.192.in-addr.arpa.", qtype: dns.TypePTR, want: []string{"web.loc."}},
+		{name: "lan without bindings", client: "192.168.1.20", qname: "cache.loc.", qtype: dns.TypeA},
+		{name: "docker weighted", qname: "api.loc.", qtype: dns.TypeA, want: []string{"172.28.0.5"}},
+		{name: "lan weighted", client: "192.168.1.20", qname: "api.loc.", qtype: dns.TypeA, want: []string{"192.168.1.11"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := new(dns.Msg)
+			r.SetQuestion(tt.qname, tt.qtype)
+			rec := dnstest.NewRecorder(&test.ResponseWriter{RemoteIP: tt.client})
+			rcode, _ := dd.ServeDNS(context.Background(), rec, r)
+			if tt.wantRcode != dns.RcodeSuccess {
+				if rcode != tt.wantRcode && (rec.Msg == nil || rec.Msg.Rcode != tt.wantRcode) {
+					t.Errorf("ServeDNS() rcode = %d, want %d", rcode, tt.wantRcode)
+				}
+				return
+			}
+			if rec.Msg == nil || len(rec.Msg.Answer) != len(tt.want) {
+				t.Fatalf("ServeDNS() = %v, want %v", rec.Msg, tt.want)
+			}
+			for i, rr := range rec.Msg.Answer {
+				var got string
+				switch r := rr.(type) {
+				case *dns.A:
+					got = r.A.String()
+				case *dns.PTR:
+					got = r.Ptr
+				}
+				if got != tt.want[i] {
+					t.Errorf("answer %d = %s, want %s", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
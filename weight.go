@@ -0,0 +1,115 @@
+package dockerdns
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	csm "github.com/mhmtszr/concurrent-swiss-map"
+)
+
+const (
+	dockerWeightLabel   = "coredns.dockerdns.weight"
+	dockerPriorityLabel = "coredns.dockerdns.priority"
+
+	// defaultWeight is the weight of containers without weight label
+	defaultWeight = 1
+)
+
+func newPublishersMap() *csm.CsMap[string, []*ContainerData] {
+	return csm.Create[string, []*ContainerData](
+		csm.WithShardCount[string, []*ContainerData](32),
+		csm.WithSize[string, []*ContainerData](100),
+	)
+}
+
+// parseWeightLabels reads weight and priority labels of container.
+func parseWeightLabels(labels map[string]string, c *ContainerData) {
+	if val, ok := labels[dockerWeightLabel]; ok {
+		w, err := strconv.ParseUint(val, 10, 16)
+		if err != nil {
+			c.notes = append(c.notes, fmt.Sprintf("label %s: weight must be in range [0, 65535]: %s", dockerWeightLabel, val))
+		} else {
+			weight := uint32(w)
+			c.weight = &weight
+		}
+	}
+	if val, ok := labels[dockerPriorityLabel]; ok {
+		p, err := strconv.ParseUint(val, 10, 16)
+		if err != nil {
+			c.notes = append(c.notes, fmt.Sprintf("label %s: priority must be in range [0, 65535]: %s", dockerPriorityLabel, val))
+		} else {
+			c.priority = uint32(p)
+		}
+	}
+}
+
+// weighted reports whether container affects selection of publishers.
+func (c *ContainerData) weighted() bool {
+	return c.weight != nil || c.priority != 0
+}
+
+// selectIPs returns addresses of host selected by priority and weight labels
+// of its publishers, false is returned if none of them has such labels.
+func (m *Map) selectIPs(host string, ipv6 bool, intn func(n int) int) ([]net.IP, bool) {
+	publishers, ok := m.weighted.Load(host)
+	if !ok {
+		return nil, false
+	}
+	family := func(c *ContainerData) []net.IP {
+		if ipv6 {
+			return c.ipv6
+		}
+		return c.ipv4
+	}
+	ips := []net.IP{}
+	for _, c := range choosePublishers(publishers, family, intn) {
+		ips = appendIPs(ips, family(c))
+	}
+	return ips, true
+}
+
+// choosePublishers selects publishers by priority and weight labels.
+// Publishers having addresses of the family with the lowest priority are
+// candidates, publishers of weight 0 are drained and never chosen. If any of
+// candidates has weight label, one of them is chosen with probability
+// proportional to weight, otherwise all are returned. None is returned
+// when all publishers are drained.
+func choosePublishers(publishers []*ContainerData, family func(c *ContainerData) []net.IP, intn func(n int) int) []*ContainerData {
+	var candidates []*ContainerData
+	for _, c := range publishers {
+		if len(family(c)) == 0 || c.weight != nil && *c.weight == 0 {
+			continue
+		}
+		if len(candidates) != 0 && c.priority > candidates[0].priority {
+			continue
+		}
+		if len(candidates) != 0 && c.priority < candidates[0].priority {
+			candidates = candidates[:0]
+		}
+		candidates = append(candidates, c)
+	}
+	total, weighted := 0, false
+	for _, c := range candidates {
+		w := defaultWeight
+		if c.weight != nil {
+			w, weighted = int(*c.weight), true
+		}
+		total += w
+	}
+	if !weighted || total == 0 {
+		return candidates
+	}
+	n := intn(total)
+	for _, c := range candidates {
+		w := defaultWeight
+		if c.weight != nil {
+			w = int(*c.weight)
+		}
+		if n < w {
+			return []*ContainerData{c}
+		}
+		n -= w
+	}
+	return candidates
+}
@@ -0,0 +1,173 @@
+package dockerdns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+func TestSelectIPs(t *testing.T) {
+	u32 := func(v uint32) *uint32 { return &v }
+	tests := []struct {
+		name       string
+		containers []*ContainerData
+		n          int // value returned by intn
+		want       []string
+		weighted   bool
+	}{
+		{
+			name: "no labels",
+			containers: []*ContainerData{
+				{id: "one", ipv4: []net.IP{parseIP("172.28.0.2")}},
+				{id: "two", ipv4: []net.IP{parseIP("172.28.0.3")}},
+			},
+		},
+		{
+			name: "stable",
+			containers: []*ContainerData{
+				{id: "stable", ipv4: []net.IP{parseIP("172.28.0.2")}, weight: u32(9)},
+				{id: "canary", ipv4: []net.IP{parseIP("172.28.0.3")}, weight: u32(1)},
+			},
+			n:        8,
+			want:     []string{"172.28.0.2"},
+			weighted: true,
+		},
+		{
+			name: "canary",
+			containers: []*ContainerData{
+				{id: "stable", ipv4: []net.IP{parseIP("172.28.0.2")}, weight: u32(9)},
+				{id: "canary", ipv4: []net.IP{parseIP("172.28.0.3")}, weight: u32(1)},
+			},
+			n:        9,
+			want:     []string{"172.28.0.3"},
+			weighted: true,
+		},
+		{
+			name: "backup by priority",
+			containers: []*ContainerData{
+				{id: "primary", ipv4: []net.IP{parseIP("172.28.0.2")}},
+				{id: "replica", ipv4: []net.IP{parseIP("172.28.0.3")}},
+				{id: "backup", ipv4: []net.IP{parseIP("172.28.0.4")}, priority: 10},
+			},
+			want:     []string{"172.28.0.2", "172.28.0.3"},
+			weighted: true,
+		},
+		{
+			name: "drained",
+			containers: []*ContainerData{
+				{id: "old", ipv4: []net.IP{parseIP("172.28.0.2")}, weight: u32(0)},
+				{id: "new", ipv4: []net.IP{parseIP("172.28.0.3")}},
+			},
+			want:     []string{"172.28.0.3"},
+			weighted: true,
+		},
+		{
+			name: "all drained",
+			containers: []*ContainerData{
+				{id: "one", ipv4: []net.IP{parseIP("172.28.0.2")}, weight: u32(0)},
+				{id: "two", ipv4: []net.IP{parseIP("172.28.0.3")}, weight: u32(0)},
+			},
+			want:     []string{},
+			weighted: true,
+		},
+		{
+			name: "drained primary falls back to backup",
+			containers: []*ContainerData{
+				{id: "primary", ipv4: []net.IP{parseIP("172.28.0.2")}, weight: u32(0)},
+				{id: "backup", ipv4: []net.IP{parseIP("172.28.0.4")}, priority: 10},
+			},
+			want:     []string{"172.28.0.4"},
+			weighted: true,
+		},
+		{
+			name: "priority of family",
+			containers: []*ContainerData{
+				{id: "v6", ipv6: []net.IP{parseIP("fd00::2")}},
+				{id: "backup", ipv4: []net.IP{parseIP("172.28.0.4")}, priority: 10},
+			},
+			want:     []string{"172.28.0.4"},
+			weighted: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMap(conflictMerge)
+			for _, c := range tt.containers {
+				c.hosts = []string{"api.loc."}
+				m.addContainer(c)
+			}
+			ips, ok := m.selectIPs("api.loc.", false, func(int) int { return tt.n })
+			if ok != tt.weighted {
+				t.Fatalf("selectIPs() weighted = %v, want %v", ok, tt.weighted)
+			}
+			if got := ipStrings(ips); ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectIPs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWeightedAnswers(t *testing.T) {
+	u32 := func(v uint32) *uint32 { return &v }
+	dd := NewDockerDiscovery("")
+	dd.Origins = []string{"loc."}
+	dd.connected = 1
+	dd.opts.anyMode = anyFull
+	https := &httpsParams{alpn: []string{"h2"}}
+	for i, w := range []uint32{1, 1, 0} {
+		dd.hmap.addContainer(&ContainerData{id: fmt.Sprintf("api%d", i), hosts: []string{"api.loc."},
+			ipv4: []net.IP{net.IPv4(172, 28, 0, byte(i+2))}, weight: u32(w), https: https})
+	}
+	dd.hmap.addContainer(&ContainerData{id: "drained", hosts: []string{"old.loc."},
+		ipv4: []net.IP{parseIP("172.28.0.9")}, weight: u32(0)})
+	// every selection chooses another container
+	calls := 0
+	dd.intn = func(n int) int {
+		calls++
+		return calls % n
+	}
+
+	query := func(qname string, qtype uint16) *dns.Msg {
+		r := new(dns.Msg)
+		r.SetQuestion(qname, qtype)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := dd.ServeDNS(context.Background(), rec, r); err != nil {
+			t.Fatalf("ServeDNS() error = %v", err)
+		}
+		return rec.Msg
+	}
+
+	// A and HTTPS hints of one answer agree on the chosen container
+	m := query("api.loc.", dns.TypeANY)
+	var addrs, hints []string
+	for _, rr := range m.Answer {
+		switch r := rr.(type) {
+		case *dns.A:
+			addrs = append(addrs, r.A.String())
+		case *dns.HTTPS:
+			for _, kv := range r.Value {
+				if h, ok := kv.(*dns.SVCBIPv4Hint); ok {
+					hints = append(hints, ipStrings(h.Hint)...)
+				}
+			}
+		}
+	}
+	if len(addrs) != 1 || !reflect.DeepEqual(addrs, hints) {
+		t.Errorf("ANY addresses = %v, HTTPS hints = %v, want the same chosen address", addrs, hints)
+	}
+	if addrs[0] == "172.28.0.4" {
+		t.Errorf("drained address %s is answered", addrs[0])
+	}
+
+	// drained name has no addresses
+	m = query("old.loc.", dns.TypeA)
+	if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 0 {
+		t.Errorf("A of drained name = %v, want NODATA", m)
+	}
+}
@@ -0,0 +1,86 @@
+package dockerdns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coredns/coredns/plugin"
+)
+
+const dockerZonesLabel = "coredns.dockerdns.zones"
+
+// projectZones maps containers of compose projects matching pattern to zones.
+type projectZones struct {
+	pattern string
+	match   func(string) bool
+	zones   []string
+}
+
+// newProjectZones parses project_zones directive arguments: PROJECT ZONES...
+// PROJECT is a glob or a regular expression enclosed in slashes.
+func (dd *DockerDiscovery) newProjectZones(args []string) (*projectZones, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("project and zones are required")
+	}
+	match, err := patternMatcher(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid project pattern %s: %s", args[0], err)
+	}
+	p := &projectZones{pattern: args[0], match: match}
+	for _, z := range args[1:] {
+		zone := plugin.Name(z).Normalize()
+		if !dd.isOrigin(zone) {
+			return nil, fmt.Errorf("zone %s is not in Origins", zone)
+		}
+		p.zones = append(p.zones, zone)
+	}
+	return p, nil
+}
+
+func (p *projectZones) String() string {
+	return p.pattern + " " + strings.Join(p.zones, " ")
+}
+
+// parseZonesLabel returns normalized zones of comma separated label value.
+func parseZonesLabel(val string) []string {
+	zones := []string{}
+	for _, z := range splitLabelList(val) {
+		zones = append(zones, plugin.Name(z).Normalize())
+	}
+	return zones
+}
+
+// containerZones returns zones container's derived names are published in,
+// in format of rzones. Zones label takes precedence over project_zones rules,
+// containers matching neither are published in all origins.
+func (dd *DockerDiscovery) containerZones(c *ContainerData) []string {
+	zones := c.zones
+	if zones == nil && c.project != "" {
+		for _, p := range dd.opts.projectZones {
+			if p.match(c.project) {
+				zones = p.zones
+				break
+			}
+		}
+	}
+	if zones == nil {
+		return dd.rzones
+	}
+	res := make([]string, 0, len(zones))
+	for _, z := range zones {
+		if !dd.isOrigin(z) {
+			c.notes = append(c.notes, fmt.Sprintf("label %s: zone %s is not in Origins", dockerZonesLabel, z))
+			continue
+		}
+		res = append(res, rzone(z))
+	}
+	return res
+}
+
+// rzone returns suffix appended to domains to make names of zone.
+func rzone(zone string) string {
+	if zone == "" || zone == "." {
+		return ""
+	}
+	return "." + zone
+}
@@ -0,0 +1,62 @@
+package dockerdns
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestContainerZones(t *testing.T) {
+	dd := NewDockerDiscovery("")
+	dd.Origins = []string{"internal.loc.", "public.loc."}
+	dd.addRZones()
+	dd.opts.byDomain = true
+	p, err := dd.newProjectZones([]string{"back*", "internal.loc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dd.opts.projectZones = []*projectZones{p}
+
+	tests := []struct {
+		name string
+		c    *ContainerData
+		want []string
+	}{
+		{
+			name: "all zones",
+			c:    &ContainerData{name: "web"},
+			want: []string{"web.internal.loc.", "web.public.loc."},
+		},
+		{
+			name: "zones label",
+			c:    &ContainerData{name: "web", zones: parseZonesLabel("public.loc")},
+			want: []string{"web.public.loc."},
+		},
+		{
+			name: "project rule",
+			c:    &ContainerData{name: "db", project: "backend"},
+			want: []string{"db.internal.loc."},
+		},
+		{
+			name: "label takes precedence over project rule",
+			c:    &ContainerData{name: "api", project: "backend", zones: parseZonesLabel("public.loc.")},
+			want: []string{"api.public.loc."},
+		},
+		{
+			name: "unknown zone",
+			c:    &ContainerData{name: "web", zones: parseZonesLabel("other.loc")},
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dd.resolveHosts(tt.c)
+			if !reflect.DeepEqual(tt.c.hosts, tt.want) {
+				t.Errorf("hosts = %v, want %v", tt.c.hosts, tt.want)
+			}
+		})
+	}
+
+	if _, err := dd.newProjectZones([]string{"backend", "other.loc"}); err == nil {
+		t.Errorf("newProjectZones() with unknown zone, want error")
+	}
+}